@@ -14,20 +14,29 @@ var compactCmd = &cobra.Command{
 	Short: "Compact the state database",
 	Long: `Delete records corresponding to the files that no longer exist and compact the database.
 
-IMPORTANT: Since the filenames are stored with relative paths, it is critical to run the compaction
-from the same directory the original processing was run - otherwise all files in the store would be
-considered non-existent and the store effectively wiped out (although the compation logic has
-safety protection against such case).
+Files under named roots (see the roots command) are stored relative to their roots and can be
+compacted from anywhere.
+
+IMPORTANT: Other filenames are stored with paths relative to the directory the processing was run
+from (unless --abs_paths was used), so it is critical to run the compaction from that same directory
+- otherwise all such files in the store would be considered non-existent and the store effectively
+wiped out (although the compation logic has safety protection against such case). Adding a named root
+for the collection avoids this.
 `,
 	Run: func(_ *cobra.Command, args []string) {
 		logger := MakeLogger()
 		nWorkers := 1
-		proc := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
-		proc.ChrTolerance = *chromTolerance
-		proc.PropTolerance = *propTolerance
+		proc, err := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		proc.ChrTolerance = *processFlags.chromTolerance
+		proc.PropTolerance = *processFlags.propTolerance
 		proc.QuietMode = *quietMode
 
-		err := proc.CompactState()
+		err = proc.CompactState()
 
 		if err != nil {
 			logger.Fatal("Compaction failed")
@@ -0,0 +1,48 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var compositeFile *string         // Where to write frames side by side
+var compareFlags *comparisonFlags // How the files are compared
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare <file1> <file2>",
+	Short: "Compare two files and show why they do or don't match",
+	Long: `Compare two video files and show the distances between each pair of their sampled frames
+(per channel and in proportions), the tolerances applied, the resulting score and the paths
+of the frames. Frames of files registered in the state (if one is used) are taken from it.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(_ *cobra.Command, args []string) {
+		logger := MakeLogger()
+		proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		if err := compareFlags.apply(proc); err != nil {
+			logger.Fatalf("Bad sample positions: %s", err)
+		}
+
+		if err := proc.CompareFiles(args[0], args[1], os.Stdout, *compositeFile); err != nil {
+			logger.Fatalf("Comparison failed: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	compositeFile = compareCmd.Flags().StringP("composite", "",
+		"", "Write the frames side by side to this image file (PNG or JPEG)")
+	compareFlags = addComparisonFlags(compareCmd)
+}
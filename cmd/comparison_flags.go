@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+// Flags controlling how videos are compared, shared by all commands comparing them
+
+type comparisonFlags struct {
+	threshold         *float32   // Maximum score of similar videos
+	chromTolerance    *float64   // Chrominance tolerance
+	propTolerance     *float64   // Proportion tolerance
+	durationTolerance *float64   // Maximum relative difference in duration of compared videos
+	numSamples        *int       // Number of frames to sample from each video
+	samplePositions   *[]float64 // Positions of sampled frames (percentages of duration)
+}
+
+func addComparisonFlags(cmd *cobra.Command) *comparisonFlags {
+	flags := new(comparisonFlags)
+
+	flags.threshold = cmd.Flags().Float32P("threshold", "",
+		processor.SimilarityThreshold, "Maximum score (0..1) of videos considered similar")
+	flags.chromTolerance = cmd.Flags().Float64P("chr_tolerance", "",
+		processor.DefaultChrominanceTolerance, "Chrominance tolerance level")
+	flags.propTolerance = cmd.Flags().Float64P("prop_tolerance", "",
+		processor.DefaultProportionTolerance, "Proportion tolerance level")
+	flags.durationTolerance = cmd.Flags().Float64P("duration_tolerance", "",
+		processor.DefaultDurationTolerance, "Maximum relative difference in duration of compared videos (0 disables)")
+	flags.numSamples = cmd.Flags().IntP("samples", "",
+		0, "Number of frames to sample from each video (overrides --sample_positions)")
+	flags.samplePositions = cmd.Flags().Float64SliceP("sample_positions", "",
+		processor.DefaultSamplePercentages, "Positions of sampled frames (percentages of video duration)")
+
+	return flags
+}
+
+// Set up the processor according to the flags. Fails on bad sample positions.
+
+func (flags *comparisonFlags) apply(proc *processor.Processor) error {
+	proc.Threshold = *flags.threshold
+	proc.ChrTolerance = *flags.chromTolerance
+	proc.PropTolerance = *flags.propTolerance
+	proc.DurationTolerance = *flags.durationTolerance
+
+	positions := *flags.samplePositions
+
+	if *flags.numSamples > 0 {
+		positions = processor.EvenSamplePositions(*flags.numSamples)
+	}
+
+	return proc.SetSamplePositions(positions)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var peekJSON *bool // Output JSON instead of text
+
+// peekCmd represents the peek command
+var peekCmd = &cobra.Command{
+	Use:   "peek",
+	Short: "Examine the data stored in the state",
+	Long: `Examine the data stored in the state: registered files, their frames and metadata, comparison
+scores and false positive matches. Use --json for output suitable for scripting.`,
+}
+
+var peekFilesCmd = &cobra.Command{
+	Use:   "files",
+	Short: "List registered files with their frame IDs",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		runPeek(func(proc *processor.Processor) error {
+			return proc.PeekFiles(os.Stdout, *peekJSON)
+		})
+	},
+}
+
+var peekFileCmd = &cobra.Command{
+	Use:   "file <file>",
+	Short: "Show frame images and metadata of a file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		runPeek(func(proc *processor.Processor) error {
+			return proc.PeekFile(os.Stdout, args[0], *peekJSON)
+		})
+	},
+}
+
+var peekScoresCmd = &cobra.Command{
+	Use:   "scores <file>",
+	Short: "List stored comparison scores of a file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		runPeek(func(proc *processor.Processor) error {
+			return proc.PeekScores(os.Stdout, args[0], *peekJSON)
+		})
+	},
+}
+
+var peekFalsePositivesCmd = &cobra.Command{
+	Use:   "false_positives",
+	Short: "List pairs of files marked as false positive matches",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		runPeek(func(proc *processor.Processor) error {
+			return proc.PeekFalsePositives(os.Stdout, *peekJSON)
+		})
+	},
+}
+
+func runPeek(peek func(proc *processor.Processor) error) {
+	logger := MakeLogger()
+	proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+	if err != nil {
+		logger.Fatalf("Initialization failed: %s", err)
+	}
+
+	if err := peek(proc); err != nil {
+		logger.Fatalf("Peek failed: %s", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(peekCmd)
+	peekCmd.AddCommand(peekFilesCmd, peekFileCmd, peekScoresCmd, peekFalsePositivesCmd)
+
+	peekJSON = peekCmd.PersistentFlags().BoolP("json", "",
+		false, "Output JSON")
+}
@@ -12,17 +12,26 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var chromTolerance *float64    // Chrominance tolerance flag
-var propTolerance *float64     // Proportion tolerance flag
-var useAbsolutePaths *bool     // Whether to store filenames with absolute paths
-var ignoreFalsePositives *bool // Tread false positives as matches
+var useAbsolutePaths *bool        // Whether to store filenames with absolute paths
+var ignoreFalsePositives *bool    // Tread false positives as matches
+var minDuration *float64          // Ignore videos shorter than this
+var maxDuration *float64          // Ignore videos longer than this
+var useIndex *bool                // Find candidate pairs via the hash index
+var hashRadius *int               // Maximum Hamming distance for index candidates
+var incremental *bool             // Only compare new files against the library
+var grouping *string              // How to group matching files
+var reportFormat *string          // Format of the report
+var reportKeeperPolicy *string    // How to pick the file to keep in each group
+var scriptQuarantine *string      // Where the generated script moves redundant files
+var processFlags *comparisonFlags // How files are compared
 
 // processCmd represents the process command
 var processCmd = &cobra.Command{
 	Use:   "process",
 	Short: "Scan video files and report similar ones.",
 	Long: `This command makes vidsim scan all video files in specified directories and reports those
-it consideres similar. The report is output in JSON format.
+it consideres similar. The report is output in JSON (default), CSV, NDJSON or HTML format, or as
+a shell script removing redundant files.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -34,11 +43,20 @@ it consideres similar. The report is output in JSON format.
 		}
 
 		logger.Infof("Running with %d parallel workers", nWorkers)
-		proc := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
-		proc.ChrTolerance = *chromTolerance
-		proc.PropTolerance = *propTolerance
+		proc, err := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
 		proc.UseAbsolutePaths = *useAbsolutePaths
 		proc.IgnoreFalsePositives = *ignoreFalsePositives
+		proc.MinDuration = *minDuration
+		proc.MaxDuration = *maxDuration
+		proc.UseIndex = *useIndex
+		proc.HashRadius = *hashRadius
+		proc.Incremental = *incremental
+		proc.Quarantine = *scriptQuarantine
 
 		if *outputFile != "" {
 			f, err := os.Create(*outputFile)
@@ -51,12 +69,28 @@ it consideres similar. The report is output in JSON format.
 		}
 
 		proc.QuietMode = *quietMode
-		err := proc.SetExclusionPattern(*excludePattern)
+		err = proc.SetExclusionPattern(*excludePattern)
 
 		if err != nil {
 			logger.Fatal("Processing failed")
 		}
 
+		if err = processFlags.apply(proc); err != nil {
+			logger.Fatalf("Bad sample positions: %s", err)
+		}
+
+		if err = proc.SetGrouping(*grouping); err != nil {
+			logger.Fatalf("Bad grouping: %s", err)
+		}
+
+		if err = proc.SetReportFormat(*reportFormat); err != nil {
+			logger.Fatalf("Bad report format: %s", err)
+		}
+
+		if err = proc.SetKeeperPolicy(*reportKeeperPolicy); err != nil {
+			logger.Fatalf("Bad keeper policy: %s", err)
+		}
+
 		err = proc.Process(args)
 
 		if err != nil {
@@ -82,8 +116,23 @@ func init() {
 		false, "Store filenames with absolute paths")
 	ignoreFalsePositives = processCmd.Flags().BoolP("ignore_false_positives", "",
 		false, "Treat false positives as matches")
-	chromTolerance = processCmd.Flags().Float64P("chr_tolerance", "",
-		processor.DefaultChrominanceTolerance, "Chrominance tolerance level")
-	propTolerance = processCmd.Flags().Float64P("prop_tolerance", "",
-		processor.DefaultProportionTolerance, "Proportion tolerance level")
+	processFlags = addComparisonFlags(processCmd)
+	minDuration = processCmd.Flags().Float64P("min_duration", "",
+		0, "Ignore videos shorter than this (seconds)")
+	maxDuration = processCmd.Flags().Float64P("max_duration", "",
+		0, "Ignore videos longer than this (seconds)")
+	useIndex = processCmd.Flags().BoolP("index", "",
+		false, "Only compare videos found similar by the perceptual hash index (faster, but may miss some matches)")
+	hashRadius = processCmd.Flags().IntP("hash_radius", "",
+		processor.DefaultHashRadius, "Maximum Hamming distance between frame hashes of candidate pairs")
+	incremental = processCmd.Flags().BoolP("incremental", "I",
+		false, "Only compare new or changed files (requires persistent state)")
+	grouping = processCmd.Flags().StringP("grouping", "",
+		processor.GroupingComponents, "How to group matching files: components, cliques or stars")
+	reportFormat = processCmd.Flags().StringP("format", "",
+		processor.ReportFormatJSON, "Report format: json, csv, ndjson, html or script")
+	reportKeeperPolicy = processCmd.Flags().StringP("keep", "",
+		"", "Mark the file to keep in each group by criteria in order of priority, e.g. resolution,bitrate,oldest")
+	scriptQuarantine = processCmd.Flags().StringP("quarantine", "",
+		"", "Make the script report move redundant files to this directory instead of deleting them")
 }
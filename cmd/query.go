@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var registerQuery *bool         // Add the queried file to the state
+var queryAbsolutePaths *bool    // Look up and register the file with its absolute path
+var queryJSON *bool             // Output JSON instead of text
+var queryFlags *comparisonFlags // How the file is compared
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query <file>",
+	Short: "Find files similar to a given one",
+	Long: `Compare a video file against all files in the state (using their cached frames) and list the
+similar ones, most similar first. The file is not added to the state unless --register is given.
+
+Use --abs_paths if the state was built with absolute paths (see --abs_paths of the process command),
+so the file is looked up and registered the same way.
+
+Exit status is 1 if similar files were found, 0 if not and 2 on errors, so the command can be
+used in ingest scripts. Sample positions (--samples or --sample_positions) must be the same the
+state was built with; files sampled at other positions are skipped with a warning.
+
+This command only works with persistent state.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		logger := MakeLogger()
+		proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Errorf("Initialization failed: %s", err)
+			os.Exit(2)
+		}
+
+		proc.UseAbsolutePaths = *queryAbsolutePaths
+
+		if err := queryFlags.apply(proc); err != nil {
+			logger.Errorf("Bad sample positions: %s", err)
+			os.Exit(2)
+		}
+
+		matches, err := proc.Query(args[0], *registerQuery, os.Stdout, *queryJSON)
+
+		if err != nil {
+			logger.Errorf("Query failed: %s", err)
+			os.Exit(2)
+		}
+
+		if len(matches) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+
+	registerQuery = queryCmd.Flags().BoolP("register", "",
+		false, "Add the file to the state")
+	queryAbsolutePaths = queryCmd.Flags().BoolP("abs_paths", "A",
+		false, "Look up and store the filename with its absolute path")
+	queryJSON = queryCmd.Flags().BoolP("json", "",
+		false, "Output JSON")
+	queryFlags = addComparisonFlags(queryCmd)
+}
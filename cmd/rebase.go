@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var rebaseFrom *string // Old prefix of stored paths
+var rebaseTo *string   // New prefix of stored paths
+var rebaseToAbs *bool  // Make stored paths absolute
+var rebaseToRel *bool  // Make stored paths relative
+var rebaseBase *string // Directory relative paths are resolved against
+var rebaseDryRun *bool // Only show what would change
+
+// rebaseCmd represents the rebase command
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase",
+	Short: "Rewrite paths of files stored in the state",
+	Long: `Rewrite the paths of all files stored in the state, e.g. after the collection was moved:
+
+    vidsim rebase --from /mnt/media --to /srv/media
+
+Paths can also be converted between relative and absolute (see --abs_paths of the process command)
+with --to_abs and --to_rel. Relative paths are resolved against --base (the current directory by
+default), which should be the directory the processing was run from.
+
+Use --dry-run to see what would change, how many of the new paths exist and which of them collide
+with other registered files. Nothing is rewritten if there are collisions.
+
+All paths are rewritten in a single transaction when they fit in one (usually up to a few tens of
+thousands of files), so either all of them are rewritten or none. Larger collections are rewritten
+in batches of 1000 files; if such a run is interrupted, the remaining paths are rewritten the next
+time the state is opened (by any command), before anything else is done with it.
+
+This command only works with persistent state.`,
+	Run: func(_ *cobra.Command, args []string) {
+		logger := MakeLogger()
+		proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		err = proc.Rebase(&processor.RebaseOptions{
+			From:       *rebaseFrom,
+			To:         *rebaseTo,
+			ToAbsolute: *rebaseToAbs,
+			ToRelative: *rebaseToRel,
+			Base:       *rebaseBase,
+			DryRun:     *rebaseDryRun,
+		}, os.Stdout)
+
+		if err != nil {
+			logger.Fatalf("Rebasing failed: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+
+	rebaseFrom = rebaseCmd.Flags().StringP("from", "",
+		"", "Prefix of the paths to rewrite")
+	rebaseTo = rebaseCmd.Flags().StringP("to", "",
+		"", "New prefix of the rewritten paths")
+	rebaseToAbs = rebaseCmd.Flags().BoolP("to_abs", "",
+		false, "Make relative paths absolute")
+	rebaseToRel = rebaseCmd.Flags().BoolP("to_rel", "",
+		false, "Make absolute paths under the base directory relative")
+	rebaseBase = rebaseCmd.Flags().StringP("base", "",
+		"", "Directory relative paths are resolved against (default: current directory)")
+	rebaseDryRun = rebaseCmd.Flags().BoolP("dry-run", "n",
+		false, "Only show what would change")
+}
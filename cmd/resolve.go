@@ -0,0 +1,76 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var resolveAction *string     // What to do with redundant files
+var keeperPolicy *string      // How to pick the file to keep
+var quarantineDir *string     // Where redundant files are moved
+var resolveReportFile *string // Report to take groups from
+var dryRun *bool              // Only show what would be done
+var undoJournal *string       // Journal of the resolution to undo
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Act on redundant files in groups of duplicates",
+	Long: `For each group of duplicates (from the last run or from a JSON report) pick the file to keep and
+perform an action on the rest: move them to a quarantine directory (preserving relative paths),
+delete them or replace them with hard or symbolic links to the kept file.
+
+Performed actions are recorded in a journal in the state directory, which can be passed to --undo
+to revert them (deleted files can't be restored, neither can files replaced by links unless
+--quarantine was set to keep the originals).
+
+This command only works with persistent state.`,
+	Run: func(_ *cobra.Command, args []string) {
+		logger := MakeLogger()
+		proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		if *undoJournal != "" {
+			if err := proc.UndoResolve(*undoJournal); err != nil {
+				logger.Fatalf("Undo failed: %s", err)
+			}
+
+			return
+		}
+
+		err = proc.Resolve(&processor.ResolveOptions{
+			Action:     *resolveAction,
+			Keep:       *keeperPolicy,
+			Quarantine: *quarantineDir,
+			ReportFile: *resolveReportFile,
+			DryRun:     *dryRun,
+		})
+
+		if err != nil {
+			logger.Fatalf("Resolution failed: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+
+	resolveAction = resolveCmd.Flags().StringP("action", "",
+		processor.ActionMove, "What to do with redundant files: move, delete, hardlink or symlink")
+	keeperPolicy = resolveCmd.Flags().StringP("keep", "",
+		processor.KeepReviewed, "Which file to keep: reviewed (picked during review), first, marked (in the report) or a keeper policy")
+	quarantineDir = resolveCmd.Flags().StringP("quarantine", "",
+		"", "Directory to move redundant files to (with links - to keep the originals in)")
+	resolveReportFile = resolveCmd.Flags().StringP("report", "",
+		"", "Take groups from this JSON report instead of the last run")
+	dryRun = resolveCmd.Flags().BoolP("dry-run", "n",
+		false, "Only show what would be done")
+	undoJournal = resolveCmd.Flags().StringP("undo", "",
+		"", "Undo actions recorded in the journal")
+}
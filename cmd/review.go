@@ -0,0 +1,48 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var reviewAll *bool // Also show groups that were already reviewed
+
+// reviewCmd represents the review command
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Interactively review groups of similar files from the last run",
+	Long: `Walk through the groups of similar files found by the last run of 'vidsim process' in a
+full-screen terminal interface, showing details of each file. For each group one can confirm it as
+duplicates, pick the file to keep or mark pairs of files as false positive matches. Decisions are
+saved in the state.
+
+When the input or output isn't a terminal, a line-oriented prompt is used instead.
+
+This command only works with persistent state.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := MakeLogger()
+		proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		err = proc.Review(os.Stdin, os.Stdout, *reviewAll)
+
+		if err != nil {
+			logger.Fatalf("Review failed: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reviewCmd)
+
+	reviewAll = reviewCmd.Flags().BoolP("all", "a",
+		false, "Also show groups that were already reviewed")
+}
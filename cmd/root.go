@@ -32,8 +32,13 @@ var quietMode *bool
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
+		// query reports found matches with exit status 1, so its errors need a different one
+		if cmd == queryCmd {
+			os.Exit(2)
+		}
+
 		os.Exit(1)
 	}
 }
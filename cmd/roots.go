@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/abelikoff/vidsim/processor"
+	"github.com/spf13/cobra"
+)
+
+var rootsJSON *bool // Output JSON instead of text
+
+// rootsCmd represents the roots command
+var rootsCmd = &cobra.Command{
+	Use:   "roots",
+	Short: "Manage named roots of the collection",
+	Long: `Files under a named root (e.g. movies=/srv/media/movies) are stored relative to it ("movies:some/file.mp4"),
+so the state doesn't depend on the directory vidsim is run from, and moving the whole root only
+takes re-adding it with the new directory.
+
+Adding a root converts paths of the files already stored under its directory (roots may be nested,
+files are stored relative to the innermost one). Relative paths are resolved against the current
+directory, so run it from the directory the processing was run from. Removing a root converts paths
+of its files to be relative to an enclosing root, or absolute if there is none.
+
+This command only works with persistent state.`,
+}
+
+var rootsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List named roots",
+	Args:  cobra.NoArgs,
+	Run: func(_ *cobra.Command, _ []string) {
+		runRoots(func(proc *processor.Processor) error {
+			return proc.ListRoots(os.Stdout, *rootsJSON)
+		})
+	},
+}
+
+var rootsAddCmd = &cobra.Command{
+	Use:   "add <name>=<directory>",
+	Short: "Add a named root",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		runRoots(func(proc *processor.Processor) error {
+			return proc.AddRoot(args[0])
+		})
+	},
+}
+
+var rootsRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a named root",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		runRoots(func(proc *processor.Processor) error {
+			return proc.RemoveRoot(args[0])
+		})
+	},
+}
+
+func runRoots(run func(proc *processor.Processor) error) {
+	logger := MakeLogger()
+	proc, err := processor.MakeProcessor(1, *stateDirectory, logger)
+
+	if err != nil {
+		logger.Fatalf("Initialization failed: %s", err)
+	}
+
+	if err := run(proc); err != nil {
+		logger.Fatalf("Failed: %s", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(rootsCmd)
+	rootsCmd.AddCommand(rootsListCmd, rootsAddCmd, rootsRemoveCmd)
+
+	rootsJSON = rootsCmd.PersistentFlags().BoolP("json", "",
+		false, "Output JSON")
+}
@@ -12,12 +12,14 @@ import (
 
 // unmatchCmd represents the unmatch command
 var unmatchCmd = &cobra.Command{
-	Use:   "unmatch",
+	Use:   "unmatch [--] <file>...",
 	Short: "Mark specified files as false positive match",
 	Long: `Even though the frame comparison might yield a match, one can indicate that specified files
 
 are not identical so that in future runs they would not be reported as a match.
 
+Put -- before the files if their names may start with '-'.
+
 This command only works with persistent state.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := MakeLogger()
@@ -28,8 +30,13 @@ This command only works with persistent state.`,
 		}
 
 		logger.Infof("Running with %d parallel workers", nWorkers)
-		proc := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
-		err := proc.Unmatch(args)
+		proc, err := processor.MakeProcessor(nWorkers, *stateDirectory, logger)
+
+		if err != nil {
+			logger.Fatalf("Initialization failed: %s", err)
+		}
+
+		err = proc.Unmatch(args)
 
 		if err != nil {
 			logger.Fatal("Processing failed")
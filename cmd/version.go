@@ -6,6 +6,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/abelikoff/vidsim/state"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +16,7 @@ var versionCmd = &cobra.Command{
 	Short: "Display program version",
 
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("vidsim %s\n", VersionString)
+		fmt.Printf("vidsim %s (state schema version %d)\n", VersionString, state.SchemaVersion)
 	},
 }
 
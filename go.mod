@@ -8,6 +8,7 @@ require (
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.1
 	github.com/vitali-fedulov/images4 v1.3.1
+	golang.org/x/term v0.22.0
 )
 
 require (
@@ -30,6 +31,5 @@ require (
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/term v0.22.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 )
@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"sort"
+)
+
+// Enumerate pairs of frames worth comparing. Pairs of videos whose durations differ by more
+// than DurationTolerance are skipped. When the hash index is used, only pairs having at least
+// one sampled frame within HashRadius are considered. In incremental mode only pairs involving
+// new frames are considered.
+
+func (proc *Processor) forEachCandidatePair(visit func(frameID1, frameID2 int)) {
+	if proc.UseIndex {
+		for _, pair := range proc.indexCandidatePairs() {
+			visit(pair[0], pair[1])
+		}
+
+		return
+	}
+
+	if proc.Incremental {
+		proc.forEachNewFramePair(visit)
+		return
+	}
+
+	proc.forEachDurationPair(visit)
+}
+
+// Count candidate pairs, recording the number of skipped ones in stats
+
+func (proc *Processor) countCandidatePairs() int {
+	numFrames := len(proc.frames)
+	numPairs := numFrames * (numFrames - 1) / 2
+
+	if proc.Incremental {
+		numNewFrames := 0
+
+		for _, frameID := range proc.frames {
+			if proc.newFrames[frameID] {
+				numNewFrames++
+			}
+		}
+
+		numPairs = numNewFrames*(numFrames-numNewFrames) + numNewFrames*(numNewFrames-1)/2
+	}
+
+	if proc.UseIndex {
+		numCandidates := len(proc.indexCandidatePairs())
+		proc.stats.NumIndexSkips = numPairs - numCandidates - proc.stats.NumDurationSkips
+		return numCandidates
+	}
+
+	numCandidates := 0
+	proc.forEachCandidatePair(func(_, _ int) { numCandidates++ })
+	proc.stats.NumDurationSkips = numPairs - numCandidates
+	return numCandidates
+}
+
+// Videos are sorted by duration so that for each video only those within DurationTolerance
+// (relative to the longer one) are visited. Videos of unknown duration are paired with everything.
+
+func (proc *Processor) forEachDurationPair(visit func(frameID1, frameID2 int)) {
+	var known, unknown []int
+
+	for _, frameID := range proc.frames {
+		if proc.DurationTolerance > 0 && proc.duration(frameID) > 0 {
+			known = append(known, frameID)
+		} else {
+			unknown = append(unknown, frameID)
+		}
+	}
+
+	sort.Slice(known, func(ii, jj int) bool {
+		return proc.duration(known[ii]) < proc.duration(known[jj])
+	})
+
+	lo := 0 // start of the duration window
+
+	for ii, frameID1 := range known {
+		minDuration := proc.duration(frameID1) * (1 - proc.DurationTolerance)
+
+		for proc.duration(known[lo]) < minDuration {
+			lo++
+		}
+
+		for jj := lo; jj < ii; jj++ {
+			visit(frameID1, known[jj])
+		}
+	}
+
+	for ii, frameID1 := range unknown {
+		for jj := range ii {
+			visit(frameID1, unknown[jj])
+		}
+
+		for _, frameID2 := range known {
+			visit(frameID1, frameID2)
+		}
+	}
+}
+
+// Query the hash index for each frame. The result is computed once and reused.
+
+func (proc *Processor) indexCandidatePairs() [][2]int {
+	if proc.candidatePairs != nil {
+		return proc.candidatePairs
+	}
+
+	proc.candidatePairs = make([][2]int, 0)
+	proc.stats.NumDurationSkips = 0
+	inRun := make(map[int]bool, len(proc.frames))
+
+	for _, frameID := range proc.frames {
+		inRun[frameID] = true
+	}
+
+	for _, frameID1 := range proc.frames {
+		if proc.Incremental && !proc.newFrames[frameID1] {
+			continue
+		}
+
+		for _, frameID2 := range proc.state.FindSimilarFrames(iconHashes(proc.icons[frameID1]), proc.HashRadius) {
+			if !inRun[frameID2] || frameID2 == frameID1 {
+				continue
+			}
+
+			// visit each pair once (in incremental mode old frames are only visited from new ones)
+
+			if frameID2 > frameID1 && (!proc.Incremental || proc.newFrames[frameID2]) {
+				continue
+			}
+
+			if !proc.similarDurations(frameID1, frameID2) {
+				proc.stats.NumDurationSkips++
+				continue
+			}
+
+			proc.candidatePairs = append(proc.candidatePairs, [2]int{frameID1, frameID2})
+		}
+	}
+
+	return proc.candidatePairs
+}
+
+func (proc *Processor) similarDurations(frameID1, frameID2 int) bool {
+	return proc.withinDurationTolerance(proc.duration(frameID1), proc.duration(frameID2))
+}
+
+// Unknown (0) durations are considered similar to anything
+
+func (proc *Processor) withinDurationTolerance(duration1, duration2 float64) bool {
+	if proc.DurationTolerance <= 0 || duration1 <= 0 || duration2 <= 0 {
+		return true
+	}
+
+	return min(duration1, duration2) >= max(duration1, duration2)*(1-proc.DurationTolerance)
+}
+
+// Video duration in seconds (0 if unknown)
+
+func (proc *Processor) duration(frameID int) float64 {
+	if metadata := proc.metadata[frameID]; metadata != nil {
+		return metadata.Duration
+	}
+
+	return 0
+}
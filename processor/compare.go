@@ -0,0 +1,225 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abelikoff/vidsim/state"
+	"github.com/vitali-fedulov/images4"
+)
+
+// A file being compared directly
+
+type comparedFile struct {
+	path     string
+	frames   []string // sampled frame images
+	icons    []images4.IconT
+	metadata *state.VideoMetadata
+}
+
+// Compare two files and print diagnostics: per-sample distances (normalized so that 1.0 is the
+// images4 default threshold), the same relative to the tolerances, and the resulting score.
+// Cached frames are used for files registered in the state, other files have their frames
+// extracted to a temporary directory (which is kept so the frames can be examined). If
+// compositeFile is set, the frames are also written there side by side.
+
+func (proc *Processor) CompareFiles(path1, path2 string, out io.Writer, compositeFile string) error {
+	tmpDir := ""
+	var files [2]*comparedFile
+
+	for ii, path := range []string{path1, path2} {
+		file, err := proc.cachedComparedFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		if file == nil {
+			if tmpDir == "" {
+				if tmpDir, err = os.MkdirTemp("", "vidsim-compare"); err != nil {
+					return err
+				}
+			}
+
+			if file, err = proc.extractComparedFile(path, tmpDir, ii+1); err != nil {
+				return err
+			}
+		}
+
+		files[ii] = file
+	}
+
+	distances := make([]state.SampleDistance, len(proc.SamplePositions))
+
+	for ii := range distances {
+		distances[ii] = iconDistance(files[0].icons[ii], files[1].icons[ii])
+	}
+
+	for ii, file := range files {
+		fmt.Fprintf(out, "File %d: %s", ii+1, file.path)
+
+		if file.metadata != nil {
+			fmt.Fprintf(out, " (%.2fs, %dx%d, %s)", file.metadata.Duration, file.metadata.Width, file.metadata.Height, file.metadata.Codec)
+		}
+
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "Tolerances: chrominance %g, proportion %g\n\n", proc.ChrTolerance, proc.PropTolerance)
+	fmt.Fprintf(out, "Sample  Position      Y       Cb      Cr    Prop  Relative  Similar\n")
+
+	for ii, distance := range distances {
+		relative := proc.relativeDistance(distance)
+		fmt.Fprintf(out, "%6d  %7.1f%%  %6.3f  %6.3f  %6.3f  %6.3f  %8.3f  %s\n",
+			ii, proc.SamplePositions[ii]*100, distance.Y, distance.Cb, distance.Cr, distance.Prop, relative, yesNo(relative <= 1))
+	}
+
+	fmt.Fprintln(out)
+
+	for ii := range distances {
+		fmt.Fprintf(out, "Frames %d: %s  %s\n", ii, files[0].frames[ii], files[1].frames[ii])
+	}
+
+	score := proc.videoScore(distances)
+	verdict := "not similar"
+
+	if score <= proc.Threshold {
+		verdict = "similar"
+	}
+
+	fmt.Fprintf(out, "\nScore: %.4f (threshold %g): %s\n", score, proc.Threshold, verdict)
+
+	if files[0].metadata != nil && files[1].metadata != nil &&
+		!proc.withinDurationTolerance(files[0].metadata.Duration, files[1].metadata.Duration) {
+		fmt.Fprintf(out, "Note: durations differ by more than %g, so the files wouldn't be compared\n", proc.DurationTolerance)
+	}
+
+	if compositeFile != "" {
+		return writeComposite(compositeFile, files[0].frames, files[1].frames)
+	}
+
+	return nil
+}
+
+// Frames of a file registered in the state, sampled at the current positions (nil if there are none)
+
+func (proc *Processor) cachedComparedFile(path string) (*comparedFile, error) {
+	frameID, found := proc.state.GetframeID(path)
+
+	if !found || !proc.hasValidSamples(frameID) {
+		return nil, nil
+	}
+
+	if !proc.loadIcons(frameID) {
+		if err := proc.makeIcons(frameID); err != nil {
+			return nil, err
+		}
+	}
+
+	file := &comparedFile{path: path, icons: proc.icons[frameID]}
+	file.metadata, _ = proc.state.GetVideoMetadata(frameID)
+
+	for ii := range proc.SamplePositions {
+		file.frames = append(file.frames, proc.state.GetFrameFileName(frameID, ii))
+	}
+
+	return file, nil
+}
+
+func (proc *Processor) extractComparedFile(path, dir string, index int) (*comparedFile, error) {
+	metadata, err := probeVideo(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := &comparedFile{path: path, metadata: metadata}
+
+	file.icons, err = proc.extractFrames(path, metadata, func(sample int) string {
+		return filepath.Join(dir, fmt.Sprintf("file%d_frame%02d.jpg", index, sample))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for ii := range proc.SamplePositions {
+		file.frames = append(file.frames, filepath.Join(dir, fmt.Sprintf("file%d_frame%02d.jpg", index, ii)))
+	}
+
+	return file, nil
+}
+
+// Write frames of both files side by side, one row per sample (PNG or JPEG depending on the extension)
+
+func writeComposite(compositeFile string, frames1, frames2 []string) error {
+	var rows [][2]image.Image
+	width, height := 0, 0
+
+	for ii := range frames1 {
+		var row [2]image.Image
+
+		for jj, frameFile := range []string{frames1[ii], frames2[ii]} {
+			img, err := images4.Open(frameFile)
+
+			if err != nil {
+				return fmt.Errorf("failed to open image file %s: %v", frameFile, err)
+			}
+
+			row[jj] = img
+		}
+
+		width = max(width, row[0].Bounds().Dx()+row[1].Bounds().Dx())
+		height += max(row[0].Bounds().Dy(), row[1].Bounds().Dy())
+		rows = append(rows, row)
+	}
+
+	composite := image.NewRGBA(image.Rect(0, 0, width, height))
+	y := 0
+
+	for _, row := range rows {
+		x := 0
+
+		for _, img := range row {
+			bounds := img.Bounds()
+			draw.Draw(composite, image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy()), img, bounds.Min, draw.Src)
+			x += bounds.Dx()
+		}
+
+		y += max(row[0].Bounds().Dy(), row[1].Bounds().Dy())
+	}
+
+	f, err := os.Create(compositeFile)
+
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(compositeFile), ".png") {
+		err = png.Encode(f, composite)
+	} else {
+		err = jpeg.Encode(f, composite, &jpeg.Options{Quality: 90})
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
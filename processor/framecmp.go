@@ -1,16 +1,28 @@
 package processor
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 
+	"github.com/abelikoff/vidsim/state"
 	"github.com/vitali-fedulov/images4"
 )
 
 const (
-	ScoreSimilar        float32 = 0.001 // score to assign for similar images
-	ScoreDifferent      float32 = 1.0   // score to assign for different images
-	SimilarityThreshold float32 = 0.5   // maximum score for similar images
+	MinScore            float32 = 0.001 // scores are kept positive since false positives are stored negated
+	SimilarityThreshold float32 = 0.5   // default maximum score for similar videos
+)
+
+// Default thresholds used by images4 (see images4 const.go). Distances are normalized by these
+// so that 1.0 corresponds to the images4.Similar cutoff.
+
+const (
+	iconThY    = float64(images4.IconSize*images4.IconSize) * 50 * 50 * 0.2
+	iconThCbCr = iconThY * 2
+	iconThProp = 0.05
 )
 
 type fcmpRequest struct {
@@ -19,10 +31,11 @@ type fcmpRequest struct {
 }
 
 type fcmpResponse struct {
-	frameID1 int
-	frameID2 int
-	score    float32
-	err      error
+	frameID1  int
+	frameID2  int
+	score     float32
+	distances []state.SampleDistance
+	err       error
 }
 
 func (req fcmpRequest) String() string {
@@ -56,35 +69,37 @@ func (proc *Processor) compareFrames() error {
 	proc.processComparisonResults(responseQueue)
 	proc.logger.Debugf("Done comparing frames")
 
-	for frameID, bucket := range proc.frameBuckets {
-		proc.groups[bucket] = append(proc.groups[bucket], frameID)
-	}
+	proc.groups = proc.buildGroups()
 
 	return nil
 }
 
 func (proc *Processor) generateComparisonJobs(requestQueue chan fcmpRequest) {
-	numFrames := len(proc.frames)
-	proc.stats.NumTotalComparisons = numFrames * (numFrames - 1) / 2
+	var stalePairs [][2]int
 
-	for ii := range numFrames {
-		frameID1 := proc.frames[ii]
+	if proc.Incremental {
+		stalePairs = proc.restoreMatches()
+	}
+
+	proc.stats.NumTotalComparisons = proc.countCandidatePairs() + len(stalePairs)
 
-		for jj := range ii {
-			frameID2 := proc.frames[jj]
-			score, found := proc.state.GetComparisonScore(frameID1, frameID2)
+	for _, pair := range stalePairs {
+		requestQueue <- fcmpRequest{frameID1: pair[0], frameID2: pair[1]}
+	}
 
-			if found {
-				proc.bucketResults(frameID1, frameID2, score)
-				proc.stats.NumCacheHits++
-				proc.stats.IncNumComparisonsMade()
-				continue
-			}
+	proc.forEachCandidatePair(func(frameID1, frameID2 int) {
+		score, found := proc.cachedScore(frameID1, frameID2)
 
-			req := fcmpRequest{frameID1: frameID1, frameID2: frameID2}
-			requestQueue <- req
+		if found {
+			proc.bucketResults(frameID1, frameID2, score)
+			proc.stats.NumCacheHits++
+			proc.stats.IncNumComparisonsMade()
+			return
 		}
-	}
+
+		req := fcmpRequest{frameID1: frameID1, frameID2: frameID2}
+		requestQueue <- req
+	})
 
 	close(requestQueue)
 	proc.logger.Debugf("All comparison jobs sent")
@@ -98,8 +113,12 @@ func (proc *Processor) processComparisonResults(responseQueue chan fcmpResponse)
 		numResponses++
 
 		if response.err == nil {
-			proc.state.SetComparisonScore(response.frameID1, response.frameID2, response.score)
-			proc.bucketResults(response.frameID1, response.frameID2, response.score)
+			proc.state.SetComparison(response.frameID1, response.frameID2, response.score, response.distances)
+
+			// recomputed pairs keep their false positive mark
+
+			info, _ := proc.state.GetComparison(response.frameID1, response.frameID2)
+			proc.bucketResults(response.frameID1, response.frameID2, info.SignedScore())
 		}
 
 		proc.stats.IncNumComparisonsMade()
@@ -112,69 +131,136 @@ func (proc *Processor) fcmpWorker(workerID int, requestQueue chan fcmpRequest, r
 	defer wg.Done()
 
 	for req := range requestQueue {
-		file1 := proc.state.GetFrameFileName(req.frameID1)
-		file2 := proc.state.GetFrameFileName(req.frameID2)
-		score, err := proc.compareImageFiles(file1, file2)
+		score, distances, err := proc.compareSamples(req.frameID1, req.frameID2)
 
 		if err != nil {
 			proc.logger.Errorf("Worker %d: comparison error: %d <> %d: %s", workerID, req.frameID1, req.frameID2, err)
 		}
 
-		responseQueue <- fcmpResponse{frameID1: req.frameID1, frameID2: req.frameID2, score: score, err: err}
+		responseQueue <- fcmpResponse{frameID1: req.frameID1, frameID2: req.frameID2, score: score, distances: distances, err: err}
 	}
 }
 
-func (proc *Processor) compareImageFiles(imageFile1 string, imageFile2 string) (float32, error) {
-	img1, err := images4.Open(imageFile1)
+// Compare two videos sample by sample, returning the score along with the per-sample
+// distances it was derived from (these are stored so that the score can be re-evaluated
+// under different tolerances without touching the frames).
+
+func (proc *Processor) compareSamples(frameID1, frameID2 int) (float32, []state.SampleDistance, error) {
+	icons1, icons2 := proc.icons[frameID1], proc.icons[frameID2]
+	numSamples := len(proc.SamplePositions)
 
-	if err != nil {
-		proc.logger.Errorf("Failed to open image file %s: %v", imageFile1, err)
-		return 0, err
+	if len(icons1) != numSamples || len(icons2) != numSamples {
+		return 0, nil, errors.New("missing frame icons")
 	}
 
-	img2, err := images4.Open(imageFile2)
+	distances := make([]state.SampleDistance, numSamples)
 
-	if err != nil {
-		proc.logger.Errorf("Failed to open image file %s: %v", imageFile2, err)
-		return 0, err
+	for ii := range numSamples {
+		distances[ii] = iconDistance(icons1[ii], icons2[ii])
 	}
 
-	// Icons are compact hash-like image representations.
+	return proc.videoScore(distances), distances, nil
+}
+
+// For each sample we compute its distance relative to the tolerances (so that values up to 1
+// mean similar frames). The video distance is the distance that a majority of samples fall
+// within, so videos with at least half of the samples matching are considered similar. The
+// distance d is then mapped into a [0..1) score as d/(1+d), making SimilarityThreshold (0.5)
+// correspond exactly to the tolerances.
+
+func (proc *Processor) videoScore(distances []state.SampleDistance) float32 {
+	relDistances := make([]float64, len(distances))
+
+	for ii, distance := range distances {
+		relDistances[ii] = proc.relativeDistance(distance)
+	}
 
-	icon1 := images4.Icon(img1)
-	icon2 := images4.Icon(img2)
+	sort.Float64s(relDistances)
+	distance := relDistances[(len(relDistances)-1)/2]
+	score := float32(distance / (1 + distance))
 
-	if images4.CustomSimilar(icon1, icon2,
-		images4.CustomCoefficients{Y: proc.ChrTolerance, Cb: proc.ChrTolerance, Cr: proc.ChrTolerance, Prop: proc.PropTolerance}) {
-		proc.logger.Debugf("SIMILAR: %s and %s", imageFile1, imageFile2)
-		return ScoreSimilar, nil
+	if math.IsInf(distance, 1) {
+		score = 1
 	}
 
-	return ScoreDifferent, nil
+	return max(score, MinScore)
 }
 
-func (proc *Processor) bucketResults(frameID1, frameID2 int, score float32) {
-	if proc.isFalsePositive(score) {
-		proc.stats.NumFalsePositives++
-	} else if score <= SimilarityThreshold {
+// Score of a previously compared pair re-evaluated under the current tolerances (negative for
+// false positives). Records lacking per-sample distances (or made with a different number of
+// samples) can't be re-evaluated - they are reported as missing so the pair gets recompared.
 
-		// determine the bucket to assign frames to
+func (proc *Processor) cachedScore(frameID1, frameID2 int) (float32, bool) {
+	info, found := proc.state.GetComparison(frameID1, frameID2)
 
-		var resultingBucket int
+	if !found {
+		return 0, false
+	}
 
-		if bucket, found := proc.frameBuckets[frameID1]; found {
-			resultingBucket = bucket
-		} else if bucket, found := proc.frameBuckets[frameID2]; found {
-			resultingBucket = bucket
-		} else {
-			resultingBucket = proc.newBucket()
-		}
+	if len(info.Distances) != len(proc.SamplePositions) {
+		proc.stats.NumInvalidated++
+		return 0, false
+	}
 
-		proc.bucketMutex.Lock()
-		proc.frameBuckets[frameID1] = resultingBucket
-		proc.frameBuckets[frameID2] = resultingBucket
+	return proc.rescore(frameID1, frameID2, info), true
+}
+
+func (proc *Processor) rescore(frameID1, frameID2 int, info state.MatchScore) float32 {
+	score := proc.videoScore(info.Distances)
+
+	if score != info.Score {
+		proc.state.SetComparison(frameID1, frameID2, score, info.Distances)
+		info.Score = score
+	}
+
+	return info.SignedScore()
+}
+
+// Icons are compact hash-like image representations.
+
+func iconDistance(icon1, icon2 images4.IconT) state.SampleDistance {
+	m1, m2, m3 := images4.EucMetric(icon1, icon2)
+
+	return state.SampleDistance{
+		Y:    float32(m1 / iconThY),
+		Cb:   float32(m2 / iconThCbCr),
+		Cr:   float32(m3 / iconThCbCr),
+		Prop: float32(images4.PropMetric(icon1, icon2) / iconThProp),
+	}
+}
+
+// Distance relative to the tolerances: frames are similar when it does not exceed 1
+
+func (proc *Processor) relativeDistance(distance state.SampleDistance) float64 {
+	return max(
+		ratio(float64(distance.Y), proc.ChrTolerance),
+		ratio(float64(distance.Cb), proc.ChrTolerance),
+		ratio(float64(distance.Cr), proc.ChrTolerance),
+		ratio(float64(distance.Prop), proc.PropTolerance))
+}
+
+func ratio(value, tolerance float64) float64 {
+	if tolerance > 0 {
+		return value / tolerance
+	}
+
+	if value > 0 {
+		return math.Inf(1)
+	}
+
+	return 0
+}
+
+func (proc *Processor) bucketResults(frameID1, frameID2 int, score float32) {
+	proc.bucketMutex.Lock()
+	defer proc.bucketMutex.Unlock()
+
+	if proc.isFalsePositive(score) {
+		proc.stats.NumFalsePositives++
+	} else if score <= proc.Threshold {
+		proc.matchSets.union(frameID1, frameID2)
+		proc.matches.addEdge(frameID1, frameID2, score)
 		proc.stats.NumMatches++
-		proc.bucketMutex.Unlock()
 	}
 }
 
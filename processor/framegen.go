@@ -4,18 +4,23 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/abelikoff/vidsim/state"
+	"github.com/vitali-fedulov/images4"
 )
 
 type fgRequest struct {
-	id             int
-	videoFile      string
-	frameID        int
-	frameImageFile string
+	id         int
+	videoFile  string
+	frameID    int
+	needFrames bool // false when frames are valid but metadata or icons are missing
 }
 
 // The response is only sent back when generation failed
@@ -26,7 +31,7 @@ type fgResponse struct {
 }
 
 func (req fgRequest) String() string {
-	return fmt.Sprintf("<fgRequest: '%s' -> '%s' >", req.videoFile, req.frameImageFile)
+	return fmt.Sprintf("<fgRequest: '%s' -> #%d >", req.videoFile, req.frameID)
 }
 
 func (rsp fgResponse) String() string {
@@ -44,7 +49,9 @@ func (proc *Processor) generateFrames(directories []string) error {
 	}
 
 	frames := make(map[int]bool)
-	go proc.fgSendJobs(directories, requestQueue, &frames)
+	staleFrames := make(map[int]bool)    // frames whose samples are regenerated
+	modifiedFrames := make(map[int]bool) // frames of files that changed since the last run
+	go proc.fgSendJobs(directories, requestQueue, &frames, staleFrames, modifiedFrames)
 
 	failedFrames := list.New()
 	go proc.fgProcessResults(responseQueue, failedFrames)
@@ -57,21 +64,47 @@ func (proc *Processor) generateFrames(directories []string) error {
 		delete(frames, frameID)
 	}
 
-	// Save the list of all frames we will be processing
+	// Scores computed from the outdated samples are no longer valid
+
+	if len(staleFrames) > 0 {
+		numDeleted := proc.state.DeleteComparisonScores(staleFrames, true)
+		proc.stats.NumScoresDropped += numDeleted
+		proc.logger.Infof("Regenerated samples for %d files, dropped %d cached scores", len(staleFrames), numDeleted)
+	}
+
+	// A modified file may be a different video altogether, so even false positive marks go
+
+	if len(modifiedFrames) > 0 {
+		numDeleted := proc.state.DeleteComparisonScores(modifiedFrames, false)
+		proc.stats.NumFilesModified = len(modifiedFrames)
+		proc.stats.NumScoresDropped += numDeleted
+		proc.logger.Infof("%d files were modified, dropped %d cached scores", len(modifiedFrames), numDeleted)
+	}
+
+	// Save the list of all frames we will be processing (along with their metadata)
 
-	proc.frames = make([]int, len(frames))
-	idx := 0
+	proc.frames = make([]int, 0, len(frames))
 
 	for frameID := range frames {
-		proc.frames[idx] = frameID
-		idx++
+		metadata, _ := proc.state.GetVideoMetadata(frameID)
+
+		if !proc.isEligibleVideo(metadata) {
+			proc.stats.NumFilesFiltered++
+			continue
+		}
+
+		proc.metadata[frameID] = metadata
+		proc.frames = append(proc.frames, frameID)
 	}
 
+	proc.updateHashIndex()
+	proc.newFrames = proc.state.GetNewFrames()
+
 	proc.logger.Debugf("Done generating frames")
 	return nil
 }
 
-func (proc *Processor) fgSendJobs(directories []string, requestQueue chan fgRequest, frames *map[int]bool) {
+func (proc *Processor) fgSendJobs(directories []string, requestQueue chan fgRequest, frames *map[int]bool, staleFrames, modifiedFrames map[int]bool) {
 	for _, dir := range directories {
 		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -93,13 +126,35 @@ func (proc *Processor) fgSendJobs(directories []string, requestQueue chan fgRequ
 
 			frameID, found := proc.state.RegisterFile(path)
 			(*frames)[frameID] = true
-			frameFile := proc.state.GetFrameFileName(frameID)
-			_, err = os.Stat(frameFile)
+			modified := proc.state.UpdateFileStamp(path, info.Size(), info.ModTime()) && found
+
+			if modified {
+				proc.logger.Debugf("file '%s' was modified", path)
+				modifiedFrames[frameID] = true
+			}
+
+			needFrames := !found || modified || !proc.hasValidSamples(frameID)
+			hasMetadata := false
+			hasIcons := false
 
-			if !found || err != nil {
-				proc.logger.Debugf("file '%s' has no frame", path)
-				proc.stats.NumFramesToGenerate++
-				req := fgRequest{frameID: frameID, videoFile: path, frameImageFile: frameFile}
+			if !needFrames {
+				_, hasMetadata = proc.state.GetVideoMetadata(frameID)
+				hasIcons = proc.loadIcons(frameID)
+			}
+
+			if needFrames || !hasMetadata || !hasIcons {
+				if needFrames {
+					proc.logger.Debugf("file '%s' has no valid frames", path)
+					proc.state.MarkFrameNew(frameID)
+
+					if found && !modified {
+						staleFrames[frameID] = true
+					}
+
+					proc.stats.NumFramesToGenerate++
+				}
+
+				req := fgRequest{frameID: frameID, videoFile: path, needFrames: needFrames}
 				requestQueue <- req
 			} else {
 				proc.stats.IncNumFilesGenerated()
@@ -129,10 +184,10 @@ func (proc *Processor) fgWorker(workerID int, requestQueue chan fgRequest, respo
 	defer wg.Done()
 
 	for req := range requestQueue {
-		err := proc.generateFrame(req.videoFile, req.frameImageFile)
+		err := proc.prepareVideo(req)
 
 		if err != nil {
-			proc.logger.Errorf("Worker %d: failed to generate frame for '%s': %s", workerID, req.videoFile, err)
+			proc.logger.Errorf("Worker %d: failed to generate frames for '%s': %s", workerID, req.videoFile, err)
 			responseQueue <- fgResponse{frameID: req.frameID, err: err}
 		}
 
@@ -140,17 +195,124 @@ func (proc *Processor) fgWorker(workerID int, requestQueue chan fgRequest, respo
 	}
 }
 
-// We try to generate a frame at 10s but if it failes (e.g. video is short)
-// we fall back to a frame at 3rd second
+// Check whether the frames for a given frame ID were sampled at the currently configured
+// positions and are all present
 
-func (proc *Processor) generateFrame(path string, frameFile string) error {
-	offsets := []string{"00:10", "00:03", "00:01"}
-	var err error
+func (proc *Processor) hasValidSamples(frameID int) bool {
+	positions, found := proc.state.GetSamplePositions(frameID)
 
-	for _, offset := range offsets {
-		err = proc.generateFrameAtOffset(path, frameFile, offset)
+	if !found || len(positions) != len(proc.SamplePositions) {
+		return false
+	}
+
+	for ii, pos := range positions {
+		if math.Abs(pos-proc.SamplePositions[ii]) > samplePositionEpsilon {
+			return false
+		}
 
-		if err == nil {
+		if _, err := os.Stat(proc.state.GetFrameFileName(frameID, ii)); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Probe the video (unless its metadata is already known) and generate its frames if needed
+
+func (proc *Processor) prepareVideo(req fgRequest) error {
+	metadata, found := proc.state.GetVideoMetadata(req.frameID)
+
+	if !found {
+		var err error
+		metadata, err = probeVideo(req.videoFile)
+
+		// frames can still be sampled at fixed offsets, the video gets probed again next time
+
+		if err != nil {
+			proc.logger.Warningf("Failed to probe '%s': %s", req.videoFile, err)
+			metadata = new(state.VideoMetadata)
+		} else {
+			proc.state.SetVideoMetadata(req.frameID, metadata)
+		}
+	}
+
+	if req.needFrames {
+		if err := proc.generateSamples(req.videoFile, req.frameID, metadata); err != nil {
+			return err
+		}
+	} else if proc.loadIcons(req.frameID) {
+		return nil
+	}
+
+	return proc.makeIcons(req.frameID)
+}
+
+// Compute icons for all sampled frames of a video and cache them (both in memory and in the state)
+
+func (proc *Processor) makeIcons(frameID int) error {
+	icons := make([]images4.IconT, len(proc.SamplePositions))
+
+	for ii := range icons {
+		frameFile := proc.state.GetFrameFileName(frameID, ii)
+		img, err := images4.Open(frameFile)
+
+		if err != nil {
+			return fmt.Errorf("failed to open image file %s: %v", frameFile, err)
+		}
+
+		icons[ii] = images4.Icon(img)
+	}
+
+	proc.state.SetIcons(frameID, icons)
+	proc.setIcons(frameID, icons)
+	return nil
+}
+
+// Load cached icons from the state into memory. Returns false if there are none (or they
+// don't match the current sampling).
+
+func (proc *Processor) loadIcons(frameID int) bool {
+	icons, found := proc.state.GetIcons(frameID)
+
+	if !found || len(icons) != len(proc.SamplePositions) {
+		return false
+	}
+
+	proc.setIcons(frameID, icons)
+	return true
+}
+
+func (proc *Processor) setIcons(frameID int, icons []images4.IconT) {
+	proc.iconMutex.Lock()
+	proc.icons[frameID] = icons
+	proc.iconMutex.Unlock()
+}
+
+// Generate one frame per sample position, each at an offset relative to the video duration
+
+func (proc *Processor) generateSamples(path string, frameID int, metadata *state.VideoMetadata) error {
+	if metadata.Duration <= 0 {
+		proc.logger.Warningf("Unknown duration of '%s', sampling at fixed offsets", path)
+	}
+
+	for ii, pos := range proc.SamplePositions {
+		if err := proc.generateSample(path, proc.state.GetFrameFileName(frameID, ii), metadata, pos); err != nil {
+			return err
+		}
+	}
+
+	proc.state.SetSamplePositions(frameID, proc.SamplePositions)
+	return nil
+}
+
+// Generate the frame at a sample position, trying the fallback offsets in turn if that fails
+
+func (proc *Processor) generateSample(path string, frameFile string, metadata *state.VideoMetadata, position float64) error {
+	var err error
+
+	for _, offset := range sampleOffsets(metadata, position) {
+		if err = proc.generateFrame(path, frameFile, offset); err == nil {
 			return nil
 		}
 
@@ -160,9 +322,40 @@ func (proc *Processor) generateFrame(path string, frameFile string) error {
 	return err
 }
 
+const samplePositionEpsilon = 1e-4 // positions are persisted as float32
+
+// When the duration of a video is unknown, sample positions are taken relative to an assumed
+// duration (so that samples still differ), with fixed offsets as a fallback for shorter videos
+
+const fallbackDuration = 60 // seconds
+
+var fallbackOffsets = []string{"00:10", "00:03", "00:01"}
+
+func sampleOffsets(metadata *state.VideoMetadata, position float64) []string {
+	if metadata.Duration <= 0 {
+		return append([]string{formatOffset(fallbackDuration * position)}, fallbackOffsets...)
+	}
+
+	return []string{sampleOffset(metadata, position)}
+}
+
+// Compute the offset for a sample position of a video with known duration. Seeking right to
+// the end of a video yields no frame, so we keep a small margin.
+
+func sampleOffset(metadata *state.VideoMetadata, position float64) string {
+	margin := 0.5
+
+	if metadata.FrameRate > 0 {
+		margin = min(margin, 2/metadata.FrameRate)
+	}
+
+	offset := min(metadata.Duration*position, metadata.Duration-margin)
+	return formatOffset(max(offset, 0))
+}
+
 // The actual frame generation logic
 
-func (proc *Processor) generateFrameAtOffset(path string, frameFile string, offset string) error {
+func (proc *Processor) generateFrame(path string, frameFile string, offset string) error {
 	proc.logger.Debugf("Generating frame at offset %s: %s -> %s", offset, path, frameFile)
 
 	program := "ffmpeg"
@@ -201,6 +394,28 @@ func (proc *Processor) generateFrameAtOffset(path string, frameFile string, offs
 	return nil
 }
 
+func formatOffset(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// Apply metadata-based filters
+
+func (proc *Processor) isEligibleVideo(metadata *state.VideoMetadata) bool {
+	if metadata == nil {
+		return true
+	}
+
+	if proc.MinDuration > 0 && metadata.Duration < proc.MinDuration {
+		return false
+	}
+
+	if proc.MaxDuration > 0 && metadata.Duration > proc.MaxDuration {
+		return false
+	}
+
+	return true
+}
+
 func (proc *Processor) isEligibleFile(path string) bool {
 	videoExtensions := map[string]bool{
 		".mp4": true, ".mov": true, ".avi": true, ".mkv": true, ".wmv": true,
@@ -0,0 +1,347 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Grouping modes
+
+const (
+	GroupingComponents = "components" // connected components of the match graph
+	GroupingCliques    = "cliques"    // maximal cliques (every member matches every other)
+	GroupingStars      = "stars"      // a representative with all the files it matches
+)
+
+// Match graph: frame ID -> matching frame ID -> score
+
+type matchGraph map[int]map[int]float32
+
+func (graph matchGraph) addEdge(frameID1, frameID2 int, score float32) {
+	if graph[frameID1] == nil {
+		graph[frameID1] = make(map[int]float32)
+	}
+
+	if graph[frameID2] == nil {
+		graph[frameID2] = make(map[int]float32)
+	}
+
+	graph[frameID1][frameID2] = score
+	graph[frameID2][frameID1] = score
+}
+
+// Sorted neighbours of a frame that belong to a given set
+
+func (graph matchGraph) neighboursWithin(frameID int, frames []int) []int {
+	var neighbours []int
+
+	for _, other := range frames {
+		if _, found := graph[frameID][other]; found {
+			neighbours = append(neighbours, other)
+		}
+	}
+
+	return neighbours
+}
+
+func (proc *Processor) SetGrouping(mode string) error {
+	switch mode {
+	case GroupingComponents, GroupingCliques, GroupingStars:
+		proc.Grouping = mode
+		return nil
+	}
+
+	return fmt.Errorf("unknown grouping mode '%s'", mode)
+}
+
+func (proc *Processor) buildGroups() map[int][]int {
+	switch proc.Grouping {
+	case GroupingCliques:
+		return proc.matches.cliqueGroups()
+	case GroupingStars:
+		return proc.matches.starGroups()
+	}
+
+	return proc.matchSets.groups()
+}
+
+// Disjoint-set (union-find) structure over frame IDs, used to group matching frames into
+// connected components of the match graph
+
+type disjointSet struct {
+	parent map[int]int
+	rank   map[int]int
+}
+
+func newDisjointSet() *disjointSet {
+	return &disjointSet{parent: make(map[int]int), rank: make(map[int]int)}
+}
+
+func (ds *disjointSet) find(frameID int) int {
+	root, found := ds.parent[frameID]
+
+	if !found {
+		ds.parent[frameID] = frameID
+		return frameID
+	}
+
+	for root != ds.parent[root] {
+		root = ds.parent[root]
+	}
+
+	// path compression
+
+	for frameID != root {
+		next := ds.parent[frameID]
+		ds.parent[frameID] = root
+		frameID = next
+	}
+
+	return root
+}
+
+func (ds *disjointSet) union(frameID1, frameID2 int) {
+	root1, root2 := ds.find(frameID1), ds.find(frameID2)
+
+	if root1 == root2 {
+		return
+	}
+
+	if ds.rank[root1] < ds.rank[root2] {
+		root1, root2 = root2, root1
+	}
+
+	ds.parent[root2] = root1
+
+	if ds.rank[root1] == ds.rank[root2] {
+		ds.rank[root1]++
+	}
+}
+
+// Produce groups (bucket -> sorted frame IDs). Each bucket is numbered after its smallest
+// frame ID, so the numbering doesn't depend on the order comparisons completed in and stays
+// stable across runs.
+
+func (ds *disjointSet) groups() map[int][]int {
+	components := make(map[int][]int)
+
+	for frameID := range ds.parent {
+		root := ds.find(frameID)
+		components[root] = append(components[root], frameID)
+	}
+
+	groups := make(map[int][]int, len(components))
+
+	for _, frames := range components {
+		sort.Ints(frames)
+		groups[frames[0]] = frames
+	}
+
+	return groups
+}
+
+// Maximal cliques found with Bron-Kerbosch algorithm (with pivoting). Since a file may belong
+// to several cliques, buckets are numbered sequentially in the order of their (sorted) members.
+
+func (graph matchGraph) cliqueGroups() map[int][]int {
+	var cliques [][]int
+	candidates := make(map[int]bool, len(graph))
+
+	for frameID := range graph {
+		candidates[frameID] = true
+	}
+
+	graph.bronKerbosch(nil, candidates, make(map[int]bool), &cliques)
+
+	for _, clique := range cliques {
+		sort.Ints(clique)
+	}
+
+	sort.Slice(cliques, func(ii, jj int) bool {
+		return lessFrames(cliques[ii], cliques[jj])
+	})
+
+	groups := make(map[int][]int, len(cliques))
+
+	for ii, clique := range cliques {
+		groups[ii+1] = clique
+	}
+
+	return groups
+}
+
+func (graph matchGraph) bronKerbosch(clique []int, candidates, excluded map[int]bool, cliques *[][]int) {
+	if len(candidates) == 0 && len(excluded) == 0 {
+		if len(clique) > 1 {
+			*cliques = append(*cliques, append([]int(nil), clique...))
+		}
+
+		return
+	}
+
+	// pick the pivot with the most neighbours among candidates
+
+	pivot, maxNeighbours := -1, -1
+
+	for _, set := range []map[int]bool{candidates, excluded} {
+		for frameID := range set {
+			numNeighbours := 0
+
+			for neighbour := range graph[frameID] {
+				if candidates[neighbour] {
+					numNeighbours++
+				}
+			}
+
+			if numNeighbours > maxNeighbours {
+				pivot, maxNeighbours = frameID, numNeighbours
+			}
+		}
+	}
+
+	var vertices []int
+
+	for frameID := range candidates {
+		if _, isNeighbour := graph[pivot][frameID]; !isNeighbour {
+			vertices = append(vertices, frameID)
+		}
+	}
+
+	for _, frameID := range vertices {
+		newCandidates := make(map[int]bool)
+		newExcluded := make(map[int]bool)
+
+		for neighbour := range graph[frameID] {
+			if candidates[neighbour] {
+				newCandidates[neighbour] = true
+			}
+
+			if excluded[neighbour] {
+				newExcluded[neighbour] = true
+			}
+		}
+
+		graph.bronKerbosch(append(clique, frameID), newCandidates, newExcluded, cliques)
+		delete(candidates, frameID)
+		excluded[frameID] = true
+	}
+}
+
+// Star groups: repeatedly pick the file matching the most not yet grouped files (ties broken
+// by the smallest frame ID) and group it with those files. Buckets are numbered after the
+// representative's frame ID.
+
+func (graph matchGraph) starGroups() map[int][]int {
+	groups := make(map[int][]int)
+	grouped := make(map[int]bool)
+	frames := make([]int, 0, len(graph))
+
+	for frameID := range graph {
+		frames = append(frames, frameID)
+	}
+
+	sort.Ints(frames)
+
+	for {
+		center, maxUngrouped := -1, 0
+
+		for _, frameID := range frames {
+			if grouped[frameID] {
+				continue
+			}
+
+			numUngrouped := 0
+
+			for neighbour := range graph[frameID] {
+				if !grouped[neighbour] {
+					numUngrouped++
+				}
+			}
+
+			if numUngrouped > maxUngrouped {
+				center, maxUngrouped = frameID, numUngrouped
+			}
+		}
+
+		if center < 0 {
+			break
+		}
+
+		members := []int{center}
+		grouped[center] = true
+
+		for neighbour := range graph[center] {
+			if !grouped[neighbour] {
+				members = append(members, neighbour)
+				grouped[neighbour] = true
+			}
+		}
+
+		sort.Ints(members)
+		groups[center] = members
+	}
+
+	return groups
+}
+
+func lessFrames(frames1, frames2 []int) bool {
+	for ii := range min(len(frames1), len(frames2)) {
+		if frames1[ii] != frames2[ii] {
+			return frames1[ii] < frames2[ii]
+		}
+	}
+
+	return len(frames1) < len(frames2)
+}
+
+// Average score of direct matches within a group (lower means more confident)
+
+func (graph matchGraph) groupScore(frames []int) float32 {
+	var total float32
+	numMatches := 0
+
+	for ii, frameID1 := range frames {
+		for _, frameID2 := range frames[:ii] {
+			if score, found := graph[frameID1][frameID2]; found {
+				total += score
+				numMatches++
+			}
+		}
+	}
+
+	if numMatches == 0 {
+		return 1
+	}
+
+	return total / float32(numMatches)
+}
+
+// Buckets ordered by confidence (most confident first), then by bucket number
+
+func (proc *Processor) bucketsByConfidence() []int {
+	buckets := sortedBuckets(proc.groups)
+	scores := make(map[int]float32, len(buckets))
+
+	for _, bucket := range buckets {
+		scores[bucket] = proc.matches.groupScore(proc.groups[bucket])
+	}
+
+	sort.SliceStable(buckets, func(ii, jj int) bool {
+		return scores[buckets[ii]] < scores[buckets[jj]]
+	})
+
+	return buckets
+}
+
+// Buckets in ascending order
+
+func sortedBuckets(groups map[int][]int) []int {
+	buckets := make([]int, 0, len(groups))
+
+	for bucket := range groups {
+		buckets = append(buckets, bucket)
+	}
+
+	sort.Ints(buckets)
+	return buckets
+}
@@ -0,0 +1,179 @@
+package processor
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HTML: a self-contained page showing the sampled frames of group members side by side.
+// Frames are embedded as data URIs so the report can be mailed or archived. Selected groups
+// can be exported as unmatch command lines (using the state the report was made with), and
+// selected files as a deletion list.
+
+type htmlReportWriter struct {
+	writer         io.Writer
+	headerWritten  bool
+	stateDirectory string // absolute path of the persistent state (empty if there is none)
+}
+
+func newHTMLReportWriter(proc *Processor, writer io.Writer) ReportWriter {
+	rw := &htmlReportWriter{writer: writer}
+
+	if dir := proc.state.GetDataDirectory(); dir != "" {
+		rw.stateDirectory, _ = filepath.Abs(dir)
+	}
+
+	return rw
+}
+
+type htmlFile struct {
+	*ReportFile
+	Frames    []template.URL // embedded sampled frames
+	Keeper    bool           // picked as the file to keep
+	Redundant bool           // not picked as the file to keep
+}
+
+func (rw *htmlReportWriter) writeHeader() error {
+	if rw.headerWritten {
+		return nil
+	}
+
+	rw.headerWritten = true
+	return htmlTemplates.ExecuteTemplate(rw.writer, "header", struct {
+		StateDirectory string
+	}{rw.stateDirectory})
+}
+
+func (rw *htmlReportWriter) WriteGroup(group *ReportGroup) error {
+	if err := rw.writeHeader(); err != nil {
+		return err
+	}
+
+	files := make([]htmlFile, len(group.Files))
+
+	for ii := range group.Files {
+		files[ii] = htmlFile{ReportFile: &group.Files[ii]}
+
+		if group.Keeper != "" {
+			files[ii].Keeper = group.Files[ii].Path == group.Keeper
+			files[ii].Redundant = !files[ii].Keeper
+		}
+
+		for _, frameFile := range group.Files[ii].frameFiles {
+			data, err := os.ReadFile(frameFile)
+
+			if err != nil {
+				continue
+			}
+
+			files[ii].Frames = append(files[ii].Frames,
+				template.URL("data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(data)))
+		}
+	}
+
+	return htmlTemplates.ExecuteTemplate(rw.writer, "group", struct {
+		*ReportGroup
+		Files []htmlFile
+	}{group, files})
+}
+
+func (rw *htmlReportWriter) Finish(summary *ReportSummary) error {
+	if err := rw.writeHeader(); err != nil {
+		return err
+	}
+
+	return htmlTemplates.ExecuteTemplate(rw.writer, "footer", summary)
+}
+
+var htmlTemplates = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": formatSize,
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).String()
+	},
+	"timestamp": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vidsim report</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.group { border: 1px solid #ccc; border-radius: 4px; margin-bottom: 1em; padding: 0.5em; }
+.group h2 { font-size: 1em; margin: 0 0 0.5em 0; }
+.file { display: flex; align-items: center; gap: 1em; margin: 0.25em 0; }
+.frames img { height: 90px; margin-right: 2px; }
+.info { font-size: 0.85em; }
+.path { font-family: monospace; word-break: break-all; }
+.keeper { font-family: sans-serif; font-weight: bold; color: #080; }
+#export { position: sticky; top: 0; background: #f4f4f4; padding: 0.5em; margin-bottom: 1em; }
+#export textarea { width: 100%; height: 8em; font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="export" data-state-directory="{{.StateDirectory}}">
+<button onclick="exportUnmatch()">Unmatch selected groups</button>
+<button onclick="exportDeletions()">List selected files for deletion</button>
+<textarea id="output" readonly></textarea>
+</div>
+{{end}}
+
+{{define "group"}}<div class="group" data-bucket="{{.Bucket}}">
+<h2><label><input type="checkbox" class="select-group"> Bucket {{.Bucket}} (score {{printf "%.4f" .Score}})</label></h2>
+{{range .Files}}<div class="file">
+<input type="checkbox" class="select-file" data-path="{{.Path}}"{{if .Redundant}} checked{{end}}>
+<div class="frames">{{range .Frames}}<img src="{{.}}">{{end}}</div>
+<div class="info">
+<div class="path">{{.Path}}{{if .Keeper}} <span class="keeper">(keep)</span>{{end}}</div>
+<div>{{if .ModTime}}{{size .Size}}{{else}}file not found{{end}}{{if .Duration}}, {{duration .Duration}}{{end}}{{if .Width}}, {{.Width}}x{{.Height}}{{end}}{{if .ModTime}}, modified {{timestamp .ModTime}}{{end}}</div>
+</div>
+</div>
+{{end}}</div>
+{{end}}
+
+{{define "footer"}}<p class="info">Generated {{timestamp .Finished}}: {{.NumFiles}} files, {{.NumComparisons}} comparisons, {{.NumGroups}} groups (threshold {{.Threshold}}, grouping {{.Grouping}}).</p>
+<script>
+function quote(path) {
+  return "'" + path.replace(/'/g, "'\\''") + "'";
+}
+
+function exportUnmatch() {
+  var lines = [];
+  var stateDirectory = document.getElementById("export").dataset.stateDirectory;
+  var command = "vidsim " + (stateDirectory ? "-d " + quote(stateDirectory) + " " : "") + "unmatch -- ";
+
+  document.querySelectorAll(".group").forEach(function (group) {
+    if (!group.querySelector(".select-group").checked) {
+      return;
+    }
+
+    var paths = [];
+    group.querySelectorAll(".select-file").forEach(function (file) {
+      paths.push(quote(file.dataset.path));
+    });
+    lines.push(command + paths.join(" "));
+  });
+
+  document.getElementById("output").value = lines.join("\n");
+}
+
+function exportDeletions() {
+  var paths = [];
+
+  document.querySelectorAll(".select-file:checked").forEach(function (file) {
+    paths.push(file.dataset.path);
+  });
+
+  document.getElementById("output").value = paths.join("\n");
+}
+</script>
+</body>
+</html>
+{{end}}
+`))
@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+// Parameters determining which pairs get compared. Incremental mode is only safe when these
+// are the same as in the last completed run - otherwise some pairs of old frames might have
+// never been compared.
+
+type runScope struct {
+	Directories       []string `json:"directories"`
+	Exclude           string   `json:"exclude"`
+	MinDuration       float64  `json:"min_duration"`
+	MaxDuration       float64  `json:"max_duration"`
+	DurationTolerance float64  `json:"duration_tolerance"`
+	UseIndex          bool     `json:"use_index"`
+	HashRadius        int      `json:"hash_radius"`
+}
+
+func (proc *Processor) runScope(directories []string) string {
+	scope := runScope{
+		MinDuration:       proc.MinDuration,
+		MaxDuration:       proc.MaxDuration,
+		DurationTolerance: proc.DurationTolerance,
+		UseIndex:          proc.UseIndex,
+		HashRadius:        proc.HashRadius,
+	}
+
+	if proc.exclusionRx != nil {
+		scope.Exclude = proc.exclusionRx.String()
+	}
+
+	for _, dir := range directories {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+
+		scope.Directories = append(scope.Directories, dir)
+	}
+
+	sort.Strings(scope.Directories)
+	signature, _ := json.Marshal(scope)
+	return string(signature)
+}
+
+// Check whether incremental mode can be used for this run
+
+func (proc *Processor) canRunIncrementally(directories []string) bool {
+	if !proc.state.IsPersistent() {
+		proc.logger.Warn("Incremental mode requires persistent state, comparing all files")
+		return false
+	}
+
+	lastRun, found := proc.state.GetLastRun()
+
+	if !found {
+		proc.logger.Warn("No completed run found, comparing all files")
+		return false
+	}
+
+	if lastRun.Scope != proc.runScope(directories) {
+		proc.logger.Warn("Parameters differ from the last completed run, comparing all files")
+		return false
+	}
+
+	return true
+}
+
+// Record the completed run: all frames in it have been compared with each other. The groups
+// are saved for later review.
+
+func (proc *Processor) completeRun(directories []string) {
+	var groups []state.GroupRecord
+
+	for _, bucket := range proc.bucketsByConfidence() {
+		frames := proc.groups[bucket]
+
+		if len(frames) < 2 {
+			continue
+		}
+
+		group := state.GroupRecord{Bucket: bucket, Frames: frames}
+
+		for _, frameID := range frames {
+			group.Files = append(group.Files, proc.filePath(frameID))
+		}
+
+		if proc.KeeperPolicy != nil {
+			group.Keeper = group.Files[proc.pickKeeperByPolicy(proc.KeeperPolicy, frames, group.Files)]
+		}
+
+		groups = append(groups, group)
+	}
+
+	proc.state.ClearNewFrames(proc.frames)
+	proc.state.SetLastGroups(groups)
+	proc.state.SetLastRun(&state.RunInfo{Scope: proc.runScope(directories), Finished: time.Now()})
+}
+
+// Rebuild groups of old frames from the stored results in a single pass over the state.
+// Returns the pairs whose results can't be re-evaluated and have to be recompared.
+
+func (proc *Processor) restoreMatches() [][2]int {
+	var stalePairs [][2]int
+	inRun := make(map[int]bool, len(proc.frames))
+	restored := make(map[[2]int]state.MatchScore)
+
+	for _, frameID := range proc.frames {
+		inRun[frameID] = true
+	}
+
+	proc.state.ForEachComparison(func(frameID1, frameID2 int, info state.MatchScore) {
+		if !inRun[frameID1] || !inRun[frameID2] || proc.newFrames[frameID1] || proc.newFrames[frameID2] {
+			return
+		}
+
+		if !proc.similarDurations(frameID1, frameID2) {
+			return
+		}
+
+		if len(info.Distances) != len(proc.SamplePositions) {
+			proc.stats.NumInvalidated++
+			stalePairs = append(stalePairs, [2]int{frameID1, frameID2})
+			return
+		}
+
+		restored[[2]int{frameID1, frameID2}] = info
+	})
+
+	// scores are updated outside of the iteration
+
+	for pair, info := range restored {
+		score := proc.rescore(pair[0], pair[1], info)
+
+		if proc.isFalsePositive(score) {
+			proc.bucketResults(pair[0], pair[1], score) // only counted as a false positive
+		} else if score <= proc.Threshold {
+			proc.stats.NumRestored++
+			proc.bucketResults(pair[0], pair[1], score)
+		}
+	}
+
+	return stalePairs
+}
+
+// Visit pairs involving at least one new frame, subject to the duration filter
+
+func (proc *Processor) forEachNewFramePair(visit func(frameID1, frameID2 int)) {
+	var known, unknown []int
+
+	for _, frameID := range proc.frames {
+		if proc.DurationTolerance > 0 && proc.duration(frameID) > 0 {
+			known = append(known, frameID)
+		} else {
+			unknown = append(unknown, frameID)
+		}
+	}
+
+	sort.Slice(known, func(ii, jj int) bool {
+		return proc.duration(known[ii]) < proc.duration(known[jj])
+	})
+
+	for _, frameID1 := range proc.frames {
+		if !proc.newFrames[frameID1] {
+			continue
+		}
+
+		visitOther := func(frameID2 int) {
+			// pairs of new frames are visited from the frame with the larger ID
+
+			if frameID2 == frameID1 || (proc.newFrames[frameID2] && frameID2 > frameID1) {
+				return
+			}
+
+			visit(frameID1, frameID2)
+		}
+
+		others := known
+		duration1 := proc.duration(frameID1)
+
+		if proc.DurationTolerance > 0 && duration1 > 0 {
+			lo := sort.Search(len(known), func(ii int) bool {
+				return proc.duration(known[ii]) >= duration1*(1-proc.DurationTolerance)
+			})
+			hi := sort.Search(len(known), func(ii int) bool {
+				return proc.duration(known[ii])*(1-proc.DurationTolerance) > duration1
+			})
+			others = known[lo:hi]
+		}
+
+		for _, frameID2 := range others {
+			visitOther(frameID2)
+		}
+
+		for _, frameID2 := range unknown {
+			visitOther(frameID2)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package processor
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+// Keeper criteria. A policy is an ordered list of criteria, each subsequent one only breaking
+// ties of the previous ones (e.g. "resolution,bitrate,oldest"). Remaining ties are broken in
+// favor of the first file of the group.
+
+const (
+	KeepResolution = "resolution" // highest resolution
+	KeepBitRate    = "bitrate"    // highest bit rate
+	KeepSize       = "size"       // largest file
+	KeepDuration   = "duration"   // longest duration
+	KeepOldest     = "oldest"     // oldest modification time
+	KeepDirectory  = "dir"        // located under a preferred directory (dir:PATH)
+	KeepContainer  = "container"  // preferred container format (container:NAME)
+	KeepCodec      = "codec"      // preferred video codec (codec:NAME)
+)
+
+type KeeperPolicy []keeperCriterion
+
+type keeperCriterion struct {
+	name string
+	arg  string // preferred directory, container or codec
+}
+
+func (policy KeeperPolicy) String() string {
+	criteria := make([]string, len(policy))
+
+	for ii, criterion := range policy {
+		criteria[ii] = criterion.name
+
+		if criterion.arg != "" {
+			criteria[ii] += ":" + criterion.arg
+		}
+	}
+
+	return strings.Join(criteria, ",")
+}
+
+// Properties of a file the keeper is picked by
+
+type keeperCandidate struct {
+	path     string
+	size     int64
+	modTime  time.Time
+	metadata *state.VideoMetadata
+}
+
+func ParseKeeperPolicy(spec string) (KeeperPolicy, error) {
+	var policy KeeperPolicy
+
+	for _, item := range strings.Split(spec, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(item), ":")
+
+		switch name {
+		case KeepResolution, KeepBitRate, KeepSize, KeepDuration, KeepOldest:
+			if arg != "" {
+				return nil, fmt.Errorf("keeper criterion '%s' takes no argument", name)
+			}
+
+		case KeepDirectory:
+			if arg == "" {
+				return nil, fmt.Errorf("keeper criterion '%s' requires a directory", name)
+			}
+
+			if absArg, err := filepath.Abs(arg); err == nil {
+				arg = absArg
+			}
+
+		case KeepContainer, KeepCodec:
+			if arg == "" {
+				return nil, fmt.Errorf("keeper criterion '%s' requires a name", name)
+			}
+
+			arg = strings.ToLower(arg)
+
+		default:
+			return nil, fmt.Errorf("unknown keeper criterion '%s'", name)
+		}
+
+		policy = append(policy, keeperCriterion{name: name, arg: arg})
+	}
+
+	return policy, nil
+}
+
+func (proc *Processor) SetKeeperPolicy(spec string) error {
+	if spec == "" {
+		proc.KeeperPolicy = nil
+		return nil
+	}
+
+	policy, err := ParseKeeperPolicy(spec)
+
+	if err != nil {
+		return err
+	}
+
+	proc.KeeperPolicy = policy
+	return nil
+}
+
+// Index of the preferred candidate
+
+func (policy KeeperPolicy) pick(candidates []keeperCandidate) int {
+	best := 0
+
+	for ii := 1; ii < len(candidates); ii++ {
+		if policy.compare(&candidates[ii], &candidates[best]) > 0 {
+			best = ii
+		}
+	}
+
+	return best
+}
+
+// Positive if c1 is preferred to c2, negative if c2 is preferred, 0 on a tie
+
+func (policy KeeperPolicy) compare(c1, c2 *keeperCandidate) int {
+	for _, criterion := range policy {
+		if result := criterion.compare(c1, c2); result != 0 {
+			return result
+		}
+	}
+
+	return 0
+}
+
+func (criterion keeperCriterion) compare(c1, c2 *keeperCandidate) int {
+	switch criterion.name {
+	case KeepResolution:
+		return cmp.Compare(c1.pixels(), c2.pixels())
+	case KeepBitRate:
+		return cmp.Compare(c1.bitRate(), c2.bitRate())
+	case KeepSize:
+		return cmp.Compare(c1.size, c2.size)
+	case KeepDuration:
+		return cmp.Compare(c1.duration(), c2.duration())
+	case KeepOldest:
+		if c1.modTime.IsZero() || c2.modTime.IsZero() {
+			return compareFlags(!c1.modTime.IsZero(), !c2.modTime.IsZero())
+		}
+
+		return c2.modTime.Compare(c1.modTime)
+	case KeepDirectory:
+		return compareFlags(c1.isUnder(criterion.arg), c2.isUnder(criterion.arg))
+	case KeepContainer:
+		return compareFlags(c1.hasContainer(criterion.arg), c2.hasContainer(criterion.arg))
+	case KeepCodec:
+		return compareFlags(c1.hasCodec(criterion.arg), c2.hasCodec(criterion.arg))
+	}
+
+	return 0
+}
+
+// Candidates having the property are preferred
+
+func compareFlags(flag1, flag2 bool) int {
+	switch {
+	case flag1 && !flag2:
+		return 1
+	case !flag1 && flag2:
+		return -1
+	}
+
+	return 0
+}
+
+func (candidate *keeperCandidate) pixels() int64 {
+	if candidate.metadata == nil {
+		return 0
+	}
+
+	return int64(candidate.metadata.Width) * int64(candidate.metadata.Height)
+}
+
+func (candidate *keeperCandidate) bitRate() int64 {
+	if candidate.metadata == nil {
+		return 0
+	}
+
+	return candidate.metadata.BitRate
+}
+
+func (candidate *keeperCandidate) duration() float64 {
+	if candidate.metadata == nil {
+		return 0
+	}
+
+	return candidate.metadata.Duration
+}
+
+func (candidate *keeperCandidate) isUnder(dir string) bool {
+	absPath, err := filepath.Abs(candidate.path)
+
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ffprobe reports container formats as a list of names (e.g. "mov,mp4,m4a,3gp,3g2,mj2")
+
+func (candidate *keeperCandidate) hasContainer(name string) bool {
+	if candidate.metadata == nil {
+		return false
+	}
+
+	for _, container := range strings.Split(strings.ToLower(candidate.metadata.Container), ",") {
+		if container == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (candidate *keeperCandidate) hasCodec(name string) bool {
+	return candidate.metadata != nil && strings.ToLower(candidate.metadata.Codec) == name
+}
+
+// Pick the keeper among files of a group
+
+func (proc *Processor) pickKeeperByPolicy(policy KeeperPolicy, frames []int, files []string) int {
+	candidates := make([]keeperCandidate, len(frames))
+
+	for ii, frameID := range frames {
+		candidates[ii].path = files[ii]
+		candidates[ii].metadata = proc.metadata[frameID]
+
+		if candidates[ii].metadata == nil {
+			candidates[ii].metadata, _ = proc.state.GetVideoMetadata(frameID)
+		}
+
+		if info, err := os.Stat(files[ii]); err == nil {
+			candidates[ii].size = info.Size()
+			candidates[ii].modTime = info.ModTime()
+		}
+	}
+
+	return policy.pick(candidates)
+}
@@ -0,0 +1,191 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+// Inspection of the data stored in the state. Each function prints either human-readable text
+// or JSON (for scripting).
+
+type PeekFile struct {
+	Path    string `json:"path"`
+	FrameID int    `json:"frame_id"`
+}
+
+type PeekFileInfo struct {
+	Path            string               `json:"path"`
+	FrameID         int                  `json:"frame_id"`
+	Frames          []string             `json:"frames"`           // images of the sampled frames
+	SamplePositions []float64            `json:"sample_positions"` // relative to duration
+	Metadata        *state.VideoMetadata `json:"metadata"`
+	New             bool                 `json:"new"` // not yet compared in a completed run
+}
+
+type PeekScore struct {
+	File1         string  `json:"file1"`
+	File2         string  `json:"file2"`
+	Score         float32 `json:"score"`
+	FalsePositive bool    `json:"false_positive"`
+}
+
+// List registered files with their frame IDs
+
+func (proc *Processor) PeekFiles(out io.Writer, asJSON bool) error {
+	files := proc.registeredFiles()
+	sort.Slice(files, func(ii, jj int) bool { return files[ii].FrameID < files[jj].FrameID })
+
+	if asJSON {
+		return writeJSON(out, files)
+	}
+
+	for _, file := range files {
+		fmt.Fprintf(out, "%8d  %s\n", file.FrameID, file.Path)
+	}
+
+	return nil
+}
+
+// Show frame images and metadata of a file
+
+func (proc *Processor) PeekFile(out io.Writer, path string, asJSON bool) error {
+	frameID, found := proc.state.GetframeID(path)
+
+	if !found {
+		return fmt.Errorf("file '%s' is unknown", path)
+	}
+
+	info := PeekFileInfo{Path: path, FrameID: frameID, Frames: make([]string, 0)}
+	info.SamplePositions, _ = proc.state.GetSamplePositions(frameID)
+	info.Metadata, _ = proc.state.GetVideoMetadata(frameID)
+	info.New = proc.state.GetNewFrames()[frameID]
+
+	for ii := range info.SamplePositions {
+		info.Frames = append(info.Frames, proc.state.GetFrameFileName(frameID, ii))
+	}
+
+	if asJSON {
+		return writeJSON(out, info)
+	}
+
+	fmt.Fprintf(out, "Path:        %s\nFrame ID:    %d\nNew:         %t\n", info.Path, info.FrameID, info.New)
+
+	if info.Metadata != nil {
+		fmt.Fprintf(out, "Duration:    %.2fs\nResolution:  %dx%d\nCodec:       %s\nFrame rate:  %.3f\nRotation:    %d\nBit rate:    %d\nContainer:   %s\n",
+			info.Metadata.Duration, info.Metadata.Width, info.Metadata.Height, info.Metadata.Codec,
+			info.Metadata.FrameRate, info.Metadata.Rotation, info.Metadata.BitRate, info.Metadata.Container)
+	}
+
+	for ii, frameFile := range info.Frames {
+		fmt.Fprintf(out, "Frame %2d:    %s (at %.1f%%)\n", ii, frameFile, info.SamplePositions[ii]*100)
+	}
+
+	return nil
+}
+
+// List stored scores of a file (most similar first)
+
+func (proc *Processor) PeekScores(out io.Writer, path string, asJSON bool) error {
+	frameID, found := proc.state.GetframeID(path)
+
+	if !found {
+		return fmt.Errorf("file '%s' is unknown", path)
+	}
+
+	files := proc.frameFiles()
+	scores := make([]PeekScore, 0)
+
+	proc.state.ForEachComparison(func(frameID1, frameID2 int, info state.MatchScore) {
+		if frameID2 == frameID {
+			frameID1, frameID2 = frameID2, frameID1
+		}
+
+		if frameID1 == frameID {
+			scores = append(scores, PeekScore{File1: path, File2: fileLabel(files, frameID2), Score: info.Score, FalsePositive: info.FalsePositive})
+		}
+	})
+
+	sort.SliceStable(scores, func(ii, jj int) bool { return scores[ii].Score < scores[jj].Score })
+	return writeScores(out, scores, asJSON)
+}
+
+// List pairs marked as false positives
+
+func (proc *Processor) PeekFalsePositives(out io.Writer, asJSON bool) error {
+	if !proc.state.IsPersistent() {
+		return errors.New("false positives are only stored in persistent state")
+	}
+
+	files := proc.frameFiles()
+	scores := make([]PeekScore, 0)
+
+	proc.state.ForEachComparison(func(frameID1, frameID2 int, info state.MatchScore) {
+		if info.FalsePositive {
+			scores = append(scores, PeekScore{File1: fileLabel(files, frameID1), File2: fileLabel(files, frameID2), Score: info.Score, FalsePositive: true})
+		}
+	})
+
+	return writeScores(out, scores, asJSON)
+}
+
+func (proc *Processor) registeredFiles() []PeekFile {
+	files := make([]PeekFile, 0)
+
+	proc.state.ForEachFile(func(path string, frameID int) {
+		files = append(files, PeekFile{Path: path, FrameID: frameID})
+	})
+
+	return files
+}
+
+// Frame ID -> file
+
+func (proc *Processor) frameFiles() map[int]string {
+	files := make(map[int]string)
+
+	proc.state.ForEachFile(func(path string, frameID int) {
+		files[frameID] = path
+	})
+
+	return files
+}
+
+// Files no longer registered are shown by their frame ID
+
+func fileLabel(files map[int]string, frameID int) string {
+	if path, found := files[frameID]; found {
+		return path
+	}
+
+	return fmt.Sprintf("<frame %d>", frameID)
+}
+
+func writeScores(out io.Writer, scores []PeekScore, asJSON bool) error {
+	if asJSON {
+		return writeJSON(out, scores)
+	}
+
+	for _, score := range scores {
+		mark := ""
+
+		if score.FalsePositive {
+			mark = "  (false positive)"
+		}
+
+		fmt.Fprintf(out, "%.4f  %s  %s%s\n", score.Score, score.File1, score.File2, mark)
+	}
+
+	return nil
+}
+
+func writeJSON(out io.Writer, value any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"github.com/vitali-fedulov/images4"
+)
+
+// Compute a 64-bit difference hash (dHash) of an icon. We use the luma channel of the central
+// 9x8 region of the icon and set a bit for each pair of horizontally adjacent pixels where
+// the left one is brighter. Since icons are cached, this never touches the frame images.
+
+func iconHash(icon images4.IconT) uint64 {
+	const size = images4.IconSize
+	var hash uint64
+
+	if len(icon.Pixels) < size*size {
+		return 0
+	}
+
+	for y := 1; y <= 8; y++ {
+		row := icon.Pixels[y*size : (y+1)*size]
+
+		for x := 1; x <= 8; x++ {
+			hash <<= 1
+
+			if row[x] > row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func iconHashes(icons []images4.IconT) []uint64 {
+	hashes := make([]uint64, len(icons))
+
+	for ii, icon := range icons {
+		hashes[ii] = iconHash(icon)
+	}
+
+	return hashes
+}
+
+// Make sure all frames we process are in the hash index, then persist the index
+
+func (proc *Processor) updateHashIndex() {
+	for _, frameID := range proc.frames {
+		proc.state.IndexFrame(frameID, iconHashes(proc.icons[frameID]))
+	}
+
+	if err := proc.state.SaveHashIndex(); err != nil {
+		proc.logger.Errorf("Failed to save hash index: %s", err)
+	}
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+// Subset of ffprobe JSON output we care about
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// Probe the video file for its duration, resolution, codec etc.
+
+func probeVideo(path string) (*state.VideoMetadata, error) {
+	program := "ffprobe"
+	args := []string{
+		"-v",
+		"error",
+		"-print_format",
+		"json",
+		"-show_format",
+		"-show_streams",
+		path}
+	output, err := exec.Command(program, args...).Output()
+
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed (%d)", exitError.ExitCode())
+		}
+
+		return nil, errors.New("failed to run ffprobe")
+	}
+
+	return parseProbeOutput(output)
+}
+
+func parseProbeOutput(output []byte) (*state.VideoMetadata, error) {
+	var probe ffprobeOutput
+
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("cannot parse ffprobe output: %v", err)
+	}
+
+	metadata := new(state.VideoMetadata)
+	metadata.Container = probe.Format.FormatName
+	metadata.Duration = parseFloat(probe.Format.Duration)
+	metadata.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	foundVideo := false
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+
+		foundVideo = true
+		metadata.Codec = stream.CodecName
+		metadata.Width = stream.Width
+		metadata.Height = stream.Height
+		metadata.FrameRate = parseFrameRate(stream.AvgFrameRate)
+
+		if metadata.FrameRate == 0 {
+			metadata.FrameRate = parseFrameRate(stream.RFrameRate)
+		}
+
+		if metadata.Duration == 0 {
+			metadata.Duration = parseFloat(stream.Duration)
+		}
+
+		// Rotation is reported either as a tag (older ffmpeg) or as display matrix side data
+
+		if rotate, found := stream.Tags["rotate"]; found {
+			metadata.Rotation, _ = strconv.Atoi(rotate)
+		} else {
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != 0 {
+					metadata.Rotation = int(sideData.Rotation)
+				}
+			}
+		}
+
+		metadata.Rotation = ((metadata.Rotation % 360) + 360) % 360
+		break
+	}
+
+	if !foundVideo {
+		return nil, errors.New("no video stream found")
+	}
+
+	return metadata, nil
+}
+
+// Parse frame rate expressed as a fraction (e.g. "30000/1001")
+
+func parseFrameRate(rate string) float64 {
+	num, den, isFraction := strings.Cut(rate, "/")
+
+	if !isFraction {
+		return parseFloat(rate)
+	}
+
+	denominator := parseFloat(den)
+
+	if denominator == 0 {
+		return 0
+	}
+
+	return parseFloat(num) / denominator
+}
+
+func parseFloat(value string) float64 {
+	result, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return result
+}
@@ -3,33 +3,49 @@ package processor
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/abelikoff/vidsim/state"
 	"github.com/sirupsen/logrus"
+	"github.com/vitali-fedulov/images4"
 )
 
 const (
 	DefaultChrominanceTolerance = 0.3
 	DefaultProportionTolerance  = 10.0
+	DefaultDurationTolerance    = 0.1
+	DefaultHashRadius           = 10
 )
 
+// Default positions of the frames sampled from each video (percentages of video duration, as
+// passed to SetSamplePositions())
+
+var DefaultSamplePercentages = []float64{5, 25, 50, 75, 95}
+
 type Processor struct {
-	numWorkers   int           // number of workers
-	frames       []int         // list of all frame IDs we will be processing
-	groups       map[int][]int // bucket -> list of frame IDs
-	state        *state.State
-	stats        StatsCollector
-	logger       *logrus.Logger
-	frameBuckets map[int]int    // frameID -> bucket
-	nextBucket   int            // next bucket number
-	exclusionRx  *regexp.Regexp // exclude files matching pattern
-	bucketMutex  sync.Mutex
-	QuietMode    bool          // be really quiet (only show warnings and errors)
-	OutputWriter *bufio.Writer // where to write the report (nil means stdout)
+	numWorkers     int                          // number of workers
+	frames         []int                        // list of all frame IDs we will be processing
+	metadata       map[int]*state.VideoMetadata // frame ID -> video metadata
+	icons          map[int][]images4.IconT      // frame ID -> icons of the sampled frames
+	candidatePairs [][2]int                     // pairs to compare found via the hash index
+	newFrames      map[int]bool                 // frames (re)generated since the last completed run
+	started        time.Time                    // when processing started
+	iconMutex      sync.Mutex
+	groups         map[int][]int // bucket -> list of frame IDs
+	state          *state.State
+	stats          StatsCollector
+	logger         *logrus.Logger
+	matchSets      *disjointSet   // connected components of the match graph
+	matches        matchGraph     // frame ID -> matching frame IDs
+	exclusionRx    *regexp.Regexp // exclude files matching pattern
+	bucketMutex    sync.Mutex
+	QuietMode      bool          // be really quiet (only show warnings and errors)
+	OutputWriter   *bufio.Writer // where to write the report (nil means stdout)
 
 	UseAbsolutePaths     bool // When true filenames will be stored in the state with absolute paths
 	IgnoreFalsePositives bool // Trat false positives as matches
@@ -41,33 +57,65 @@ type Processor struct {
 
 	ChrTolerance  float64 // Luma and Chrominance tolerance
 	PropTolerance float64 // proportion tolerance
+
+	SamplePositions []float64 // positions (0..1, relative to duration) of the frames sampled from each video
+
+	MinDuration float64 // ignore videos shorter than this (seconds, 0 means no limit)
+	MaxDuration float64 // ignore videos longer than this (seconds, 0 means no limit)
+
+	// Videos whose durations differ by more than this fraction (of the longer one) are not compared.
+	// 0 disables the filter, which is useful when looking for trimmed clips.
+
+	DurationTolerance float64
+
+	UseIndex   bool // find candidate pairs via the perceptual hash index instead of comparing all pairs
+	HashRadius int  // maximum Hamming distance between hashes of candidate frames
+
+	Incremental bool // only compare new frames, restoring matches between old ones from the state
+
+	Grouping string // how matching files are grouped (components, cliques or stars)
+
+	Threshold float32 // maximum score for videos to be considered similar
+
+	ReportFormat string // json, csv, ndjson, html or script
+	Quarantine   string // where the script report moves redundant files to (they are deleted if not set)
+
+	KeeperPolicy KeeperPolicy // how to pick the file to keep in each group (nil means not picking)
 }
 
-func MakeProcessor(numWorkers int, stateDirectory string, logger *logrus.Logger) *Processor {
+func MakeProcessor(numWorkers int, stateDirectory string, logger *logrus.Logger) (*Processor, error) {
 	if numWorkers < 1 || numWorkers > 64 {
-		logger.Fatalf("Bad number of workers: %d", numWorkers)
+		return nil, fmt.Errorf("bad number of workers: %d", numWorkers)
 	}
 
 	proc := new(Processor)
 	proc.numWorkers = numWorkers
 	proc.logger = logger
 	proc.state = state.MakeState()
-	proc.nextBucket = 1
 	proc.ChrTolerance = DefaultChrominanceTolerance
 	proc.PropTolerance = DefaultProportionTolerance
+	proc.SetSamplePositions(DefaultSamplePercentages)
+	proc.DurationTolerance = DefaultDurationTolerance
+	proc.HashRadius = DefaultHashRadius
 
 	proc.bucketMutex = sync.Mutex{}
 
 	err := proc.state.Init(stateDirectory, logger)
 
 	if err != nil {
-		logger.Fatalf("Failed to initialize state: %s", err)
+		return nil, fmt.Errorf("failed to initialize state: %w", err)
 	}
 
 	proc.groups = make(map[int][]int)
-	proc.frameBuckets = make(map[int]int)
-
-	return proc
+	proc.metadata = make(map[int]*state.VideoMetadata)
+	proc.icons = make(map[int][]images4.IconT)
+	proc.matchSets = newDisjointSet()
+	proc.matches = make(matchGraph)
+	proc.Grouping = GroupingComponents
+	proc.Threshold = SimilarityThreshold
+	proc.ReportFormat = ReportFormatJSON
+
+	return proc, nil
 }
 
 func (proc *Processor) SetExclusionPattern(pattern string) error {
@@ -80,11 +128,51 @@ func (proc *Processor) SetExclusionPattern(pattern string) error {
 	return err
 }
 
+// Set positions of the sampled frames (as percentages of video duration)
+
+func (proc *Processor) SetSamplePositions(percentages []float64) error {
+	if len(percentages) < 1 {
+		return errors.New("at least one sample position is required")
+	}
+
+	positions := make([]float64, len(percentages))
+
+	for ii, pct := range percentages {
+		if pct < 0 || pct > 100 {
+			return fmt.Errorf("bad sample position: %g%%", pct)
+		}
+
+		positions[ii] = pct / 100
+	}
+
+	proc.SamplePositions = positions
+	return nil
+}
+
+// Produce n sample positions (as percentages of video duration) evenly spread between 5% and 95%
+
+func EvenSamplePositions(n int) []float64 {
+	const first, last = 5.0, 95.0
+
+	if n == 1 {
+		return []float64{50}
+	}
+
+	percentages := make([]float64, n)
+
+	for ii := range n {
+		percentages[ii] = first + float64(ii)*(last-first)/float64(n-1)
+	}
+
+	return percentages
+}
+
 func (proc *Processor) Process(directories []string) error {
 	if len(directories) < 1 {
 		proc.logger.Fatal("No directories passed")
 	}
 
+	proc.started = time.Now()
 	proc.stats.QuietMode = proc.QuietMode
 	canProceed := true
 
@@ -101,11 +189,16 @@ func (proc *Processor) Process(directories []string) error {
 		return errors.New("bad parameters passed")
 	}
 
+	if proc.Incremental {
+		proc.Incremental = proc.canRunIncrementally(directories)
+	}
+
 	proc.stats.NumFilesToProcess = proc.countVideoFiles(directories)
 	proc.generateFrames(directories)
 	proc.compareFrames()
 	proc.DebugDump()
 	proc.GenerateReport()
+	proc.completeRun(directories)
 	proc.ShowSummary()
 	return nil
 }
@@ -177,12 +270,6 @@ func (proc *Processor) countVideoFiles(directories []string) int {
 	return numFiles
 }
 
-func (proc *Processor) newBucket() int {
-	bucket := proc.nextBucket
-	proc.nextBucket++
-	return bucket
-}
-
 func (proc *Processor) DebugDump() {
 	proc.state.DebugDump()
 	proc.logger.Debugf("--- groups ---------------------------\n%v\n", proc.groups)
@@ -0,0 +1,203 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/abelikoff/vidsim/state"
+	"github.com/vitali-fedulov/images4"
+)
+
+// Frame ID used for an unregistered query file (registered frame IDs start with 1)
+
+const queryFrameID = 0
+
+type QueryMatch struct {
+	File  string  `json:"file"`
+	Score float32 `json:"score"`
+}
+
+// Compare a single file against all files in the state (using their cached icons) and print
+// the matching ones, most similar first. The file is only added to the state if register is
+// set. Returns the matches found.
+
+func (proc *Processor) Query(path string, register bool, out io.Writer, asJSON bool) ([]QueryMatch, error) {
+	if !proc.state.IsPersistent() {
+		return nil, errors.New("querying requires persistent state")
+	}
+
+	if proc.UseAbsolutePaths {
+		var err error
+
+		if path, err = normalizePath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	frameID, err := proc.prepareQueryFile(path, register)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]QueryMatch, 0)
+	seen := map[int]bool{frameID: true}
+	numUnsampled := 0 // files without frames at the current sample positions
+
+	// an unregistered query file might still be known to the state - don't match it with itself
+
+	if knownID, found := proc.state.GetframeID(path); found {
+		seen[knownID] = true
+	}
+
+	proc.state.ForEachFile(func(otherPath string, otherID int) {
+		if seen[otherID] {
+			return
+		}
+
+		seen[otherID] = true
+
+		if _, err := os.Stat(otherPath); err != nil {
+			return
+		}
+
+		if !proc.hasValidSamples(otherID) {
+			numUnsampled++
+			return
+		}
+
+		if !proc.loadIcons(otherID) {
+			return
+		}
+
+		proc.metadata[otherID], _ = proc.state.GetVideoMetadata(otherID)
+
+		if !proc.similarDurations(frameID, otherID) {
+			return
+		}
+
+		score, distances, err := proc.compareSamples(frameID, otherID)
+
+		if err != nil {
+			proc.logger.Errorf("Failed to compare with '%s': %s", otherPath, err)
+			return
+		}
+
+		if register {
+			proc.state.SetComparison(frameID, otherID, score, distances)
+			info, _ := proc.state.GetComparison(frameID, otherID)
+			score = info.SignedScore()
+		}
+
+		if !proc.isFalsePositive(score) && score <= proc.Threshold {
+			matches = append(matches, QueryMatch{File: otherPath, Score: max(score, -score)})
+		}
+	})
+
+	if numUnsampled > 0 {
+		proc.logger.Warnf("Skipped %d files not sampled at the current positions (use the sample positions the state was built with)", numUnsampled)
+	}
+
+	sort.SliceStable(matches, func(ii, jj int) bool { return matches[ii].Score < matches[jj].Score })
+
+	if asJSON {
+		return matches, writeJSON(out, matches)
+	}
+
+	for _, match := range matches {
+		fmt.Fprintf(out, "%.4f  %s\n", match.Score, match.File)
+	}
+
+	return matches, nil
+}
+
+// Probe the query file and compute the icons of its frames. Registered files have their frames
+// (and everything else) stored in the state, otherwise frames are extracted to a temporary
+// directory.
+
+func (proc *Processor) prepareQueryFile(path string, register bool) (int, error) {
+	if register {
+		frameID, found := proc.state.RegisterFile(path)
+		modified := false
+
+		if info, err := os.Stat(path); err == nil {
+			modified = proc.state.UpdateFileStamp(path, info.Size(), info.ModTime()) && found
+		}
+
+		needFrames := !found || modified || !proc.hasValidSamples(frameID)
+
+		if needFrames {
+			proc.state.MarkFrameNew(frameID)
+
+			if found {
+				proc.state.DeleteComparisonScores(map[int]bool{frameID: true}, !modified)
+			}
+		}
+
+		if err := proc.prepareVideo(fgRequest{frameID: frameID, videoFile: path, needFrames: needFrames}); err != nil {
+			return frameID, err
+		}
+
+		proc.metadata[frameID], _ = proc.state.GetVideoMetadata(frameID)
+		proc.state.IndexFrame(frameID, iconHashes(proc.icons[frameID]))
+
+		if err := proc.state.SaveHashIndex(); err != nil {
+			proc.logger.Errorf("Failed to save hash index: %s", err)
+		}
+
+		return frameID, nil
+	}
+
+	metadata, err := probeVideo(path)
+
+	if err != nil {
+		return queryFrameID, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "vidsim-query")
+
+	if err != nil {
+		return queryFrameID, err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	icons, err := proc.extractFrames(path, metadata, func(sample int) string {
+		return filepath.Join(tmpDir, fmt.Sprintf("frame%02d.jpg", sample))
+	})
+
+	if err != nil {
+		return queryFrameID, err
+	}
+
+	proc.setIcons(queryFrameID, icons)
+	proc.metadata[queryFrameID] = metadata
+	return queryFrameID, nil
+}
+
+// Extract frames of a video at the sample positions (not recording them in the state) and
+// compute their icons
+
+func (proc *Processor) extractFrames(path string, metadata *state.VideoMetadata, frameFile func(sample int) string) ([]images4.IconT, error) {
+	icons := make([]images4.IconT, len(proc.SamplePositions))
+
+	for ii, pos := range proc.SamplePositions {
+		if err := proc.generateSample(path, frameFile(ii), metadata, pos); err != nil {
+			return nil, err
+		}
+
+		img, err := images4.Open(frameFile(ii))
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to open image file %s: %v", frameFile(ii), err)
+		}
+
+		icons[ii] = images4.Icon(img)
+	}
+
+	return icons, nil
+}
@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type RebaseOptions struct {
+	From       string // prefix of the paths to rewrite
+	To         string // what the prefix is replaced with
+	ToAbsolute bool   // make relative paths absolute (relative to Base)
+	ToRelative bool   // make absolute paths under Base relative to it
+	Base       string // directory relative paths are resolved against (the current one if empty)
+	DryRun     bool   // only report what would change
+}
+
+// Rewrite the paths of all registered files, e.g. after the collection was moved to another
+// location or to switch between relative and absolute paths. The prefix is replaced first, then
+// the resulting path is converted. New paths colliding with registered files (or with each other)
+// are reported, and the paths aren't rewritten then.
+
+func (proc *Processor) Rebase(opts *RebaseOptions, out io.Writer) error {
+	if !proc.state.IsPersistent() {
+		return errors.New("rebasing requires persistent state")
+	}
+
+	if opts.ToAbsolute && opts.ToRelative {
+		return errors.New("paths can't be made both absolute and relative")
+	}
+
+	if (opts.From == "") != (opts.To == "") {
+		return errors.New("both the old and the new prefix are required")
+	}
+
+	if opts.From == "" && !opts.ToAbsolute && !opts.ToRelative {
+		return errors.New("nothing to do")
+	}
+
+	base, err := filepath.Abs(opts.Base)
+
+	if err != nil {
+		return err
+	}
+
+	renames := make(map[string]string)
+	registered := make(map[string]bool)
+	numFiles, numExisting := 0, 0
+
+	proc.state.ForEachFile(func(path string, _ int) {
+		numFiles++
+		registered[path] = true
+		newPath := rebasePath(path, opts, base)
+
+		if newPath == path {
+			return
+		}
+
+		renames[path] = newPath
+
+		if _, err := os.Stat(newPath); err == nil {
+			numExisting++
+		}
+	})
+
+	paths := make([]string, 0, len(renames))
+
+	for path := range renames {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	collisions := rebaseCollisions(paths, renames, registered)
+
+	if opts.DryRun {
+		for _, path := range paths {
+			if collision, found := collisions[path]; found {
+				fmt.Fprintf(out, "'%s' -> '%s' (collides with %s)\n", path, renames[path], collision)
+			} else {
+				fmt.Fprintf(out, "'%s' -> '%s'\n", path, renames[path])
+			}
+		}
+	} else if len(collisions) > 0 {
+		for _, path := range paths {
+			if collision, found := collisions[path]; found {
+				proc.logger.Errorf("'%s' -> '%s' collides with %s", path, renames[path], collision)
+			}
+		}
+
+		return fmt.Errorf("%d new paths collide with other files (see --dry-run)", len(collisions))
+	} else if len(renames) > 0 {
+		if err := proc.state.RenamePaths(renames); err != nil {
+			return err
+		}
+	}
+
+	verb := "Rewrote"
+
+	if opts.DryRun {
+		verb = "Would rewrite"
+	}
+
+	fmt.Fprintf(out, "%s %d of %d paths (%d of the new paths exist", verb, len(renames), numFiles, numExisting)
+
+	if opts.DryRun {
+		fmt.Fprintf(out, ", %d collide with other files", len(collisions))
+	}
+
+	fmt.Fprintln(out, ")")
+	return nil
+}
+
+// Renamed paths whose new path is already registered (and not renamed away itself) or is also the
+// new path of another file (old path -> description of the collision)
+
+func rebaseCollisions(paths []string, renames map[string]string, registered map[string]bool) map[string]string {
+	collisions := make(map[string]string)
+	targets := make(map[string]string) // new path -> first old path renamed to it
+
+	for _, path := range paths {
+		newPath := renames[path]
+
+		if _, renamed := renames[newPath]; registered[newPath] && !renamed {
+			collisions[path] = "a registered file"
+		} else if other, found := targets[newPath]; found {
+			collisions[path] = fmt.Sprintf("'%s'", other)
+		} else {
+			targets[newPath] = path
+		}
+	}
+
+	return collisions
+}
+
+func rebasePath(path string, opts *RebaseOptions, base string) string {
+	if opts.From != "" {
+		from := filepath.Clean(opts.From)
+
+		if path == from {
+			path = filepath.Clean(opts.To)
+		} else if rest, found := strings.CutPrefix(path, strings.TrimSuffix(from, string(filepath.Separator))+string(filepath.Separator)); found {
+			path = filepath.Join(opts.To, rest)
+		}
+	}
+
+	if opts.ToAbsolute && !filepath.IsAbs(path) {
+		return filepath.Join(base, path)
+	}
+
+	if opts.ToRelative && filepath.IsAbs(path) {
+		rel, err := filepath.Rel(base, path)
+
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestRebasePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		opts RebaseOptions
+		want string
+	}{
+		{"exact prefix", "/mnt/media", RebaseOptions{From: "/mnt/media", To: "/srv/media"}, "/srv/media"},
+		{"path under prefix", "/mnt/media/a/b.mp4", RebaseOptions{From: "/mnt/media", To: "/srv/media"}, "/srv/media/a/b.mp4"},
+		{"prefix with trailing slash", "/mnt/media/b.mp4", RebaseOptions{From: "/mnt/media/", To: "/srv/media/"}, "/srv/media/b.mp4"},
+		{"partial path component", "/mnt/mediax/b.mp4", RebaseOptions{From: "/mnt/media", To: "/srv/media"}, "/mnt/mediax/b.mp4"},
+		{"partial last component", "/mnt/med", RebaseOptions{From: "/mnt/media", To: "/srv/media"}, "/mnt/med"},
+		{"unrelated path", "/home/b.mp4", RebaseOptions{From: "/mnt/media", To: "/srv/media"}, "/home/b.mp4"},
+		{"root prefix", "/mnt/b.mp4", RebaseOptions{From: "/", To: "/backup"}, "/backup/mnt/b.mp4"},
+		{"relative prefix", "media/b.mp4", RebaseOptions{From: "media", To: "videos"}, "videos/b.mp4"},
+		{"to absolute", "media/b.mp4", RebaseOptions{ToAbsolute: true}, "/home/user/media/b.mp4"},
+		{"to absolute when absolute", "/mnt/b.mp4", RebaseOptions{ToAbsolute: true}, "/mnt/b.mp4"},
+		{"to relative", "/home/user/media/b.mp4", RebaseOptions{ToRelative: true}, "media/b.mp4"},
+		{"to relative outside base", "/home/other/b.mp4", RebaseOptions{ToRelative: true}, "/home/other/b.mp4"},
+		{"to relative on partial component", "/home/username/b.mp4", RebaseOptions{ToRelative: true}, "/home/username/b.mp4"},
+		{"prefix then to absolute", "media/b.mp4", RebaseOptions{From: "media", To: "videos", ToAbsolute: true}, "/home/user/videos/b.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebasePath(tt.path, &tt.opts, "/home/user"); got != tt.want {
+				t.Errorf("rebasePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebaseCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string          // registered paths in order
+		renames map[string]string // old path -> new path
+		want    map[string]string // old path -> collision
+	}{
+		{
+			name:    "no collision",
+			paths:   []string{"/a/1", "/a/2"},
+			renames: map[string]string{"/a/1": "/b/1", "/a/2": "/b/2"},
+			want:    map[string]string{},
+		},
+		{
+			name:    "swap",
+			paths:   []string{"/a", "/b"},
+			renames: map[string]string{"/a": "/b", "/b": "/a"},
+			want:    map[string]string{},
+		},
+		{
+			name:    "chain",
+			paths:   []string{"/a", "/b"},
+			renames: map[string]string{"/a": "/b", "/b": "/c"},
+			want:    map[string]string{},
+		},
+		{
+			name:    "registered file",
+			paths:   []string{"/a", "/b"},
+			renames: map[string]string{"/a": "/b"},
+			want:    map[string]string{"/a": "a registered file"},
+		},
+		{
+			name:    "same new path",
+			paths:   []string{"/a/1", "/b/1", "/c/1"},
+			renames: map[string]string{"/a/1": "/d/1", "/b/1": "/d/1", "/c/1": "/d/1"},
+			want:    map[string]string{"/b/1": "'/a/1'", "/c/1": "'/a/1'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var renamed []string
+			registered := make(map[string]bool)
+
+			for _, path := range tt.paths {
+				registered[path] = true
+
+				if _, found := tt.renames[path]; found {
+					renamed = append(renamed, path)
+				}
+			}
+
+			if got := rebaseCollisions(renamed, tt.renames, registered); !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,97 @@ package processor
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"time"
 )
 
+// Report formats
+
+const (
+	ReportFormatJSON   = "json"   // single JSON document (see Report)
+	ReportFormatCSV    = "csv"    // one row per group member
+	ReportFormatNDJSON = "ndjson" // one group per line
+	ReportFormatHTML   = "html"   // self-contained page with frame thumbnails
+	ReportFormatScript = "script" // shell script removing redundant files
+)
+
+// Version of the report schema. It is bumped whenever existing fields change meaning or are
+// removed (adding fields doesn't require a new version).
+
+const ReportVersion = 1
+
+type Report struct {
+	Version int           `json:"version"`
+	Summary ReportSummary `json:"summary"`
+	Groups  []ReportGroup `json:"groups"`
+}
+
+// Parameters and results of the run that produced the report
+
+type ReportSummary struct {
+	Started           time.Time `json:"started"`
+	Finished          time.Time `json:"finished"`
+	ChrTolerance      float64   `json:"chr_tolerance"`
+	PropTolerance     float64   `json:"prop_tolerance"`
+	DurationTolerance float64   `json:"duration_tolerance"`
+	SamplePositions   []float64 `json:"sample_positions"`
+	Threshold         float32   `json:"threshold"`
+	Grouping          string    `json:"grouping"`
+	KeeperPolicy      string    `json:"keeper_policy,omitempty"`
+	NumFiles          int       `json:"num_files"`
+	NumComparisons    int       `json:"num_comparisons"`
+	NumMatches        int       `json:"num_matches"`
+	NumFalsePositives int       `json:"num_false_positives"`
+	NumGroups         int       `json:"num_groups"`
+}
+
+type ReportGroup struct {
+	Bucket int           `json:"bucket"`
+	Score  float32       `json:"score"` // average score of direct matches (lower means more confident)
+	Files  []ReportFile  `json:"files"`
+	Scores []ReportScore `json:"scores"` // scores of all compared pairs within the group
+
+	// set when a keeper policy is used
+
+	Keeper    string   `json:"keeper,omitempty"`    // file to keep
+	Redundant []string `json:"redundant,omitempty"` // the rest of the files
+}
+
+type ReportFile struct {
+	Path     string     `json:"path"`
+	Size     int64      `json:"size,omitempty"`
+	ModTime  *time.Time `json:"mtime,omitempty"`
+	Duration float64    `json:"duration,omitempty"` // seconds
+	Width    int        `json:"width,omitempty"`
+	Height   int        `json:"height,omitempty"`
+	Matches  []string   `json:"matches"` // group members this file directly matched
+
+	frameFiles []string // sampled frames (only used by the HTML report)
+}
+
+type ReportScore struct {
+	File1 string  `json:"file1"`
+	File2 string  `json:"file2"`
+	Score float32 `json:"score"`
+}
+
+func (proc *Processor) SetReportFormat(format string) error {
+	if _, found := reportWriters[format]; !found {
+		return fmt.Errorf("unknown report format '%s'", format)
+	}
+
+	proc.ReportFormat = format
+	return nil
+}
+
+// Groups are passed to the report writer (and flushed) one by one as soon as they are built,
+// followed by the run summary
+
 func (proc *Processor) GenerateReport() {
 	var writer *bufio.Writer
 
@@ -17,47 +104,286 @@ func (proc *Processor) GenerateReport() {
 
 	defer writer.Flush()
 
-	// Don't generate empty JSON list - produce empty file instead
+	reportWriter := reportWriters[proc.ReportFormat](proc, writer)
+	numGroups := 0
 
-	empty := true
+	for _, bucket := range proc.bucketsByConfidence() {
+		frames := proc.groups[bucket]
 
-	for _, frames := range proc.groups {
 		if len(frames) < 2 {
 			continue
 		}
 
-		empty = false
-		break
+		group := proc.reportGroup(bucket, frames)
+
+		if err := reportWriter.WriteGroup(&group); err != nil {
+			proc.logger.Errorf("Failed to write report: %s", err)
+			return
+		}
+
+		writer.Flush()
+		numGroups++
 	}
 
-	if empty {
-		return
+	summary := proc.reportSummary(numGroups)
+
+	if err := reportWriter.Finish(&summary); err != nil {
+		proc.logger.Errorf("Failed to write report: %s", err)
 	}
+}
 
-	fmt.Fprint(writer, "[")
-	bucketsep := "\n  "
+func (proc *Processor) reportSummary(numGroups int) ReportSummary {
+	return ReportSummary{
+		Started:           proc.started,
+		Finished:          time.Now(),
+		ChrTolerance:      proc.ChrTolerance,
+		PropTolerance:     proc.PropTolerance,
+		DurationTolerance: proc.DurationTolerance,
+		SamplePositions:   proc.SamplePositions,
+		Threshold:         proc.Threshold,
+		Grouping:          proc.Grouping,
+		KeeperPolicy:      proc.KeeperPolicy.String(),
+		NumFiles:          proc.stats.NumFilesToProcess,
+		NumComparisons:    proc.stats.NumTotalComparisons,
+		NumMatches:        proc.stats.NumMatches,
+		NumFalsePositives: proc.stats.NumFalsePositives,
+		NumGroups:         numGroups,
+	}
+}
 
-	for bucket, frames := range proc.groups {
-		if len(frames) < 2 {
-			continue
+func (proc *Processor) reportGroup(bucket int, frames []int) ReportGroup {
+	group := ReportGroup{
+		Bucket: bucket,
+		Score:  proc.matches.groupScore(frames),
+		Files:  make([]ReportFile, 0, len(frames)),
+		Scores: make([]ReportScore, 0),
+	}
+
+	for _, frameID := range frames {
+		file := proc.reportFile(frameID)
+
+		for _, matchID := range proc.matches.neighboursWithin(frameID, frames) {
+			file.Matches = append(file.Matches, proc.filePath(matchID))
 		}
 
-		fmt.Fprintf(writer, "%s{\n    \"bucket\": %d,\n    \"files\": [\n", bucketsep, bucket)
-		bucketsep = ",\n  "
+		group.Files = append(group.Files, file)
+	}
 
-		for ii, frameID := range frames {
-			filesep := ","
+	if proc.KeeperPolicy != nil {
+		files := make([]string, len(frames))
 
-			if ii == len(frames)-1 {
-				filesep = ""
+		for ii := range group.Files {
+			files[ii] = group.Files[ii].Path
+		}
+
+		keeper := proc.pickKeeperByPolicy(proc.KeeperPolicy, frames, files)
+		group.Keeper = files[keeper]
+		group.Redundant = append(append([]string{}, files[:keeper]...), files[keeper+1:]...)
+	}
+
+	for ii, frameID1 := range frames {
+		for _, frameID2 := range frames[ii+1:] {
+			score, found := proc.pairScore(frameID1, frameID2)
+
+			if !found {
+				continue
 			}
 
-			videoFile, _ := proc.state.GetImageFile(frameID)
-			fmt.Fprintf(writer, "      \"%s\"%s\n", videoFile, filesep)
+			group.Scores = append(group.Scores, ReportScore{
+				File1: group.Files[ii].Path,
+				File2: proc.filePath(frameID2),
+				Score: score,
+			})
 		}
+	}
+
+	return group
+}
+
+func (proc *Processor) reportFile(frameID int) ReportFile {
+	file := ReportFile{Path: proc.filePath(frameID), Matches: make([]string, 0)}
+
+	if info, err := os.Stat(file.Path); err == nil {
+		modTime := info.ModTime()
+		file.Size = info.Size()
+		file.ModTime = &modTime
+	}
+
+	for ii := range proc.SamplePositions {
+		file.frameFiles = append(file.frameFiles, proc.state.GetFrameFileName(frameID, ii))
+	}
+
+	if metadata := proc.metadata[frameID]; metadata != nil {
+		file.Duration = metadata.Duration
+		file.Width = metadata.Width
+		file.Height = metadata.Height
+	}
+
+	return file
+}
+
+func (proc *Processor) filePath(frameID int) string {
+	videoFile, _ := proc.state.GetImageFile(frameID)
+	return videoFile
+}
+
+// Score of a pair of frames: taken from the match graph when they matched, otherwise
+// re-evaluated from the stored comparison (pairs that were never compared aren't found)
+
+func (proc *Processor) pairScore(frameID1, frameID2 int) (float32, bool) {
+	if score, found := proc.matches[frameID1][frameID2]; found {
+		return score, true
+	}
+
+	info, found := proc.state.GetComparison(frameID1, frameID2)
+
+	if !found {
+		return 0, false
+	}
+
+	if len(info.Distances) == len(proc.SamplePositions) {
+		return proc.videoScore(info.Distances), true
+	}
+
+	return info.Score, true
+}
+
+// Report writers receive groups one at a time followed by the run summary
+
+type ReportWriter interface {
+	WriteGroup(group *ReportGroup) error
+	Finish(summary *ReportSummary) error
+}
+
+var reportWriters = map[string]func(proc *Processor, writer io.Writer) ReportWriter{
+	ReportFormatJSON:   newJSONReportWriter,
+	ReportFormatCSV:    newCSVReportWriter,
+	ReportFormatNDJSON: newNDJSONReportWriter,
+	ReportFormatHTML:   newHTMLReportWriter,
+	ReportFormatScript: newScriptReportWriter,
+}
+
+// JSON: the whole report is written at once, once the summary is known
+
+type jsonReportWriter struct {
+	writer io.Writer
+	report Report
+}
+
+func newJSONReportWriter(_ *Processor, writer io.Writer) ReportWriter {
+	return &jsonReportWriter{
+		writer: writer,
+		report: Report{Version: ReportVersion, Groups: make([]ReportGroup, 0)},
+	}
+}
+
+func (rw *jsonReportWriter) WriteGroup(group *ReportGroup) error {
+	rw.report.Groups = append(rw.report.Groups, *group)
+	return nil
+}
+
+func (rw *jsonReportWriter) Finish(summary *ReportSummary) error {
+	rw.report.Summary = *summary
+	encoder := json.NewEncoder(rw.writer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&rw.report)
+}
+
+// NDJSON: each group is written as a single line as soon as it's available
+
+type ndjsonReportWriter struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONReportWriter(_ *Processor, writer io.Writer) ReportWriter {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return &ndjsonReportWriter{encoder: encoder}
+}
+
+func (rw *ndjsonReportWriter) WriteGroup(group *ReportGroup) error {
+	return rw.encoder.Encode(group)
+}
+
+func (rw *ndjsonReportWriter) Finish(summary *ReportSummary) error {
+	return nil
+}
+
+// CSV: one row per group member
+
+type csvReportWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func newCSVReportWriter(_ *Processor, writer io.Writer) ReportWriter {
+	return &csvReportWriter{writer: csv.NewWriter(writer)}
+}
+
+func (rw *csvReportWriter) writeHeader() {
+	if !rw.headerWritten {
+		rw.writer.Write([]string{"bucket", "score", "path", "size", "mtime", "duration", "width", "height", "role"})
+		rw.headerWritten = true
+	}
+}
+
+func (rw *csvReportWriter) WriteGroup(group *ReportGroup) error {
+	rw.writeHeader()
+
+	for _, file := range group.Files {
+		var modTime, role string
+
+		if file.ModTime != nil {
+			modTime = file.ModTime.Format(time.RFC3339)
+		}
+
+		if group.Keeper != "" {
+			role = "redundant"
+
+			if file.Path == group.Keeper {
+				role = "keeper"
+			}
+		}
+
+		rw.writer.Write([]string{
+			strconv.Itoa(group.Bucket),
+			strconv.FormatFloat(float64(group.Score), 'f', 4, 32),
+			file.Path,
+			strconv.FormatInt(file.Size, 10),
+			modTime,
+			strconv.FormatFloat(file.Duration, 'f', -1, 64),
+			strconv.Itoa(file.Width),
+			strconv.Itoa(file.Height),
+			role,
+		})
+	}
+
+	rw.writer.Flush()
+	return rw.writer.Error()
+}
+
+func (rw *csvReportWriter) Finish(summary *ReportSummary) error {
+	rw.writeHeader()
+	rw.writer.Flush()
+	return rw.writer.Error()
+}
+
+// Human-readable file size
+
+func formatSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, suffix := float64(size)/unit, 0
 
-		fmt.Fprint(writer, "    ]\n  }")
+	for value >= unit && suffix < 3 {
+		value /= unit
+		suffix++
 	}
 
-	fmt.Fprint(writer, "\n]")
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[suffix])
 }
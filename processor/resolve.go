@@ -0,0 +1,590 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+// Resolution actions performed on redundant files
+
+const (
+	ActionMove     = "move"     // move to the quarantine directory
+	ActionDelete   = "delete"   // delete
+	ActionHardlink = "hardlink" // replace with a hard link to the keeper
+	ActionSymlink  = "symlink"  // replace with a symbolic link to the keeper
+)
+
+// Keeper policies
+
+const (
+	KeepReviewed = "reviewed" // the file picked during review (groups without one are skipped)
+	KeepFirst    = "first"    // the first file of the group
+	KeepMarked   = "marked"   // the keeper marked in the report (or picked by the last run's --keep)
+)
+
+type ResolveOptions struct {
+	Action     string // what to do with redundant files
+	Keep       string // reviewed, first, marked or a keeper policy (see ParseKeeperPolicy)
+	Quarantine string // where redundant files are moved (for links - where originals are kept, if set)
+	ReportFile string // take groups from this JSON report instead of the last run
+	DryRun     bool   // only show what would be done
+}
+
+// Journal entry recording an action performed on a redundant file. Each action is recorded
+// twice: before it's performed (started) and once it's done or failed, so actions interrupted by
+// a crash can still be found.
+
+type journalEntry struct {
+	Action      string `json:"action"`
+	File        string `json:"file"`
+	FrameID     int    `json:"frame_id"`
+	Keeper      string `json:"keeper"`
+	Quarantined string `json:"quarantined,omitempty"` // where the original file was moved
+	Status      string `json:"status,omitempty"`      // empty in journals of older versions (done)
+}
+
+// Journal entry statuses
+
+const (
+	journalStarted = "started"
+	journalDone    = "done"
+	journalFailed  = "failed"
+)
+
+// Perform an action on all files in each group except for the keeper. Each action is recorded in
+// a journal (in the state directory) before it's performed, and the journal can be used to undo
+// it. The run stops if the journal can't be written.
+
+func (proc *Processor) Resolve(opts *ResolveOptions) error {
+	if !proc.state.IsPersistent() {
+		return errors.New("resolving requires persistent state")
+	}
+
+	switch opts.Action {
+	case ActionMove:
+		if opts.Quarantine == "" {
+			return errors.New("moving files requires a quarantine directory")
+		}
+	case ActionDelete, ActionHardlink, ActionSymlink:
+	default:
+		return fmt.Errorf("unknown action '%s'", opts.Action)
+	}
+
+	var policy KeeperPolicy
+
+	switch opts.Keep {
+	case KeepReviewed, KeepFirst, KeepMarked:
+	default:
+		var err error
+
+		if policy, err = ParseKeeperPolicy(opts.Keep); err != nil {
+			return fmt.Errorf("bad keeper policy: %w", err)
+		}
+	}
+
+	groups, err := proc.resolutionGroups(opts.ReportFile)
+
+	if err != nil {
+		return err
+	}
+
+	var journal *os.File
+
+	if !opts.DryRun {
+		journalFile := proc.state.GetJournalFileName(fmt.Sprintf("resolve-%s.jsonl", time.Now().Format("20060102-150405")))
+
+		if err := os.MkdirAll(filepath.Dir(journalFile), 0755); err != nil {
+			return err
+		}
+
+		if journal, err = os.OpenFile(journalFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+			return err
+		}
+
+		defer journal.Close()
+		fmt.Printf("Journal: %s\n", journalFile)
+	}
+
+	numResolved, numFailed := 0, 0
+
+	// Groups may overlap (e.g. with cliques grouping), so a file kept in one group is never
+	// resolved in another one, and groups whose keeper was already resolved are skipped
+
+	keepers := make(map[string]int) // path -> bucket it was kept in
+	resolved := make(map[string]bool)
+
+	for _, group := range groups {
+		keeper, err := proc.pickKeeper(&group, opts.Keep, policy)
+
+		if err != nil {
+			proc.logger.Warnf("Skipping bucket %d: %s", group.Bucket, err)
+			continue
+		}
+
+		if resolved[group.Files[keeper]] {
+			proc.logger.Warnf("Skipping bucket %d: keeper '%s' was resolved in an earlier group", group.Bucket, group.Files[keeper])
+			continue
+		}
+
+		if _, err := os.Stat(group.Files[keeper]); err != nil {
+			proc.logger.Warnf("Skipping bucket %d: keeper is not accessible: %s", group.Bucket, err)
+			continue
+		}
+
+		if _, found := keepers[group.Files[keeper]]; !found {
+			keepers[group.Files[keeper]] = group.Bucket
+		}
+
+		for ii, path := range group.Files {
+			if ii == keeper || resolved[path] {
+				continue
+			}
+
+			if bucket, found := keepers[path]; found {
+				proc.logger.Infof("Not resolving '%s' in bucket %d: it's kept in bucket %d", path, group.Bucket, bucket)
+				continue
+			}
+
+			entry := journalEntry{Action: opts.Action, File: path, FrameID: group.Frames[ii], Keeper: group.Files[keeper]}
+
+			if opts.DryRun {
+				fmt.Printf("Would %s '%s' (keeping '%s')\n", opts.Action, path, entry.Keeper)
+				resolved[path] = true
+				continue
+			}
+
+			if err := prepareResolution(&entry, opts); err != nil {
+				proc.logger.Errorf("Failed to %s '%s': %s", opts.Action, path, err)
+				numFailed++
+				continue
+			}
+
+			if err := writeJournalEntry(journal, &entry, journalStarted); err != nil {
+				return fmt.Errorf("cannot write journal: %w", err)
+			}
+
+			err := proc.resolveFile(&entry, opts, group.Frames[keeper])
+			status := journalDone
+
+			if err != nil {
+				status = journalFailed
+			}
+
+			if err := writeJournalEntry(journal, &entry, status); err != nil {
+				return fmt.Errorf("cannot write journal: %w", err)
+			}
+
+			if err != nil {
+				proc.logger.Errorf("Failed to %s '%s': %s", opts.Action, path, err)
+				numFailed++
+				continue
+			}
+
+			resolved[path] = true
+			fmt.Printf("%s '%s' (keeping '%s')\n", opts.Action, path, entry.Keeper)
+			numResolved++
+		}
+	}
+
+	if !opts.DryRun {
+		fmt.Printf("Resolved %d files, %d failed\n", numResolved, numFailed)
+	}
+
+	if numFailed > 0 {
+		return fmt.Errorf("failed to resolve %d files", numFailed)
+	}
+
+	return nil
+}
+
+// Append an entry to the journal and make sure it's on disk before going on
+
+func writeJournalEntry(journal *os.File, entry *journalEntry, status string) error {
+	entry.Status = status
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return journal.Sync()
+}
+
+// Groups from the last run or from a JSON report. Groups of a report with files that aren't
+// registered in the state are skipped.
+
+func (proc *Processor) resolutionGroups(reportFile string) ([]state.GroupRecord, error) {
+	if reportFile == "" {
+		groups, _ := proc.state.GetLastGroups()
+		return groups, nil
+	}
+
+	data, err := os.ReadFile(reportFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("bad report '%s': %w", reportFile, err)
+	}
+
+	if report.Version != ReportVersion {
+		return nil, fmt.Errorf("unsupported report version %d", report.Version)
+	}
+
+	groups := make([]state.GroupRecord, 0, len(report.Groups))
+
+	for _, reportGroup := range report.Groups {
+		group := state.GroupRecord{Bucket: reportGroup.Bucket, Keeper: reportGroup.Keeper}
+
+		for _, file := range reportGroup.Files {
+			frameID, found := proc.state.GetframeID(file.Path)
+
+			if !found {
+				proc.logger.Warnf("Skipping bucket %d: '%s' is not registered", reportGroup.Bucket, file.Path)
+				break
+			}
+
+			group.Frames = append(group.Frames, frameID)
+			group.Files = append(group.Files, file.Path)
+		}
+
+		if len(group.Files) == len(reportGroup.Files) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups, nil
+}
+
+// Index of the file to keep in a group (policy is only used when keep isn't one of the fixed
+// choices)
+
+func (proc *Processor) pickKeeper(group *state.GroupRecord, keep string, policy KeeperPolicy) (int, error) {
+	switch keep {
+	case KeepFirst:
+		return 0, nil
+
+	case KeepReviewed:
+		decision, found := proc.state.GetGroupDecision(group.Frames)
+
+		if !found || indexOf(group.Frames, decision.Keeper) < 0 {
+			return 0, errors.New("no keeper picked during review")
+		}
+
+		return indexOf(group.Frames, decision.Keeper), nil
+
+	case KeepMarked:
+		for ii, path := range group.Files {
+			if group.Keeper != "" && path == group.Keeper {
+				return ii, nil
+			}
+		}
+
+		return 0, errors.New("no keeper marked")
+	}
+
+	return proc.pickKeeperByPolicy(policy, group.Frames, group.Files), nil
+}
+
+// Check that an action can be performed and fill in where the original file goes, before the
+// action is journaled
+
+func prepareResolution(entry *journalEntry, opts *ResolveOptions) error {
+	if entry.File == entry.Keeper {
+		return errors.New("file is the keeper")
+	}
+
+	if opts.Quarantine != "" && opts.Action != ActionDelete {
+		var err error
+		entry.Quarantined, err = quarantinePath(opts.Quarantine, entry.File)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(entry.Quarantined); err == nil {
+			return fmt.Errorf("'%s' already exists", entry.Quarantined)
+		}
+	}
+
+	return nil
+}
+
+func (proc *Processor) resolveFile(entry *journalEntry, opts *ResolveOptions, keeperFrameID int) error {
+	switch opts.Action {
+	case ActionMove:
+		if err := moveFile(entry.File, entry.Quarantined); err != nil {
+			return err
+		}
+
+		proc.state.DeleteFile(entry.File)
+		proc.state.SetframeID(entry.Quarantined, entry.FrameID)
+
+	case ActionDelete:
+		if err := os.Remove(entry.File); err != nil {
+			return err
+		}
+
+		proc.state.DeleteFile(entry.File)
+
+	case ActionHardlink, ActionSymlink:
+		if err := replaceWithLink(entry.File, entry.Keeper, entry.Quarantined, opts.Action == ActionSymlink); err != nil {
+			return err
+		}
+
+		// the link now has the keeper's content
+
+		proc.state.SetframeID(entry.File, keeperFrameID)
+
+		if entry.Quarantined != "" {
+			proc.state.SetframeID(entry.Quarantined, entry.FrameID)
+		}
+	}
+
+	return nil
+}
+
+// Undo actions recorded in a journal (in reverse order). Deleted files can't be restored, neither
+// can the originals replaced by links (unless they were kept in the quarantine directory). Failed
+// actions are skipped, as are interrupted ones which evidently weren't performed.
+
+func (proc *Processor) UndoResolve(journalFile string) error {
+	f, err := os.Open(journalFile)
+
+	if err != nil {
+		return err
+	}
+
+	var entries []journalEntry
+	started := make(map[string]int) // file -> index of its started action in entries
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		var entry journalEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			f.Close()
+			return fmt.Errorf("bad journal '%s': %w", journalFile, err)
+		}
+
+		switch entry.Status {
+		case journalStarted:
+			started[entry.File] = len(entries)
+
+		case journalDone, journalFailed:
+			if index, found := started[entry.File]; found {
+				entries[index].Status = entry.Status
+				delete(started, entry.File)
+				continue
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	f.Close()
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	numFailed := 0
+
+	for ii := len(entries) - 1; ii >= 0; ii-- {
+		entry := entries[ii]
+
+		if entry.Status == journalFailed {
+			continue
+		}
+
+		if entry.Status == journalStarted && !isInterruptedActionDone(&entry) {
+			proc.logger.Infof("Not undoing %s of '%s': it was interrupted before being performed", entry.Action, entry.File)
+			continue
+		}
+
+		if err := proc.undoEntry(&entry); err != nil {
+			proc.logger.Errorf("Cannot undo %s of '%s': %s", entry.Action, entry.File, err)
+			numFailed++
+			continue
+		}
+
+		fmt.Printf("Restored '%s'\n", entry.File)
+	}
+
+	// make sure the journal isn't applied twice
+
+	if err := os.Rename(journalFile, journalFile+".undone"); err != nil {
+		return err
+	}
+
+	if numFailed > 0 {
+		return fmt.Errorf("failed to undo %d actions", numFailed)
+	}
+
+	return nil
+}
+
+// Whether an action interrupted by a crash was (at least partially) performed: the original was
+// moved to quarantine or the file is gone
+
+func isInterruptedActionDone(entry *journalEntry) bool {
+	if entry.Quarantined != "" {
+		if _, err := os.Lstat(entry.Quarantined); err == nil {
+			return true
+		}
+	}
+
+	_, err := os.Lstat(entry.File)
+	return err != nil
+}
+
+func (proc *Processor) undoEntry(entry *journalEntry) error {
+	switch entry.Action {
+	case ActionMove:
+		if err := moveFile(entry.Quarantined, entry.File); err != nil {
+			return err
+		}
+
+	case ActionHardlink, ActionSymlink:
+		if entry.Quarantined == "" {
+			return errors.New("the original file wasn't kept")
+		}
+
+		if err := os.Remove(entry.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := moveFile(entry.Quarantined, entry.File); err != nil {
+			return err
+		}
+
+	default:
+		return errors.New("the file was deleted")
+	}
+
+	proc.state.DeleteFile(entry.Quarantined)
+	proc.state.SetframeID(entry.File, entry.FrameID)
+	return nil
+}
+
+// Location of a file in the quarantine directory. Relative paths are preserved, absolute ones
+// (or those pointing outside of the current directory) are placed under their full path.
+
+func quarantinePath(quarantine, path string) (string, error) {
+	path = filepath.Clean(path)
+
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		absPath, err := filepath.Abs(path)
+
+		if err != nil {
+			return "", err
+		}
+
+		path = strings.TrimPrefix(absPath, filepath.VolumeName(absPath))
+	}
+
+	return filepath.Join(quarantine, path), nil
+}
+
+// Rename a file, falling back to copying when crossing filesystems
+
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(from)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+	info, err := src.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+
+	if err = dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	os.Chtimes(to, info.ModTime(), info.ModTime())
+	return os.Remove(from)
+}
+
+// Replace a file with a link to the keeper. The link is created next to the file first so that
+// the file is never lost if linking fails. The original is moved to quarantine (if set).
+
+func replaceWithLink(path, keeper, quarantined string, symbolic bool) error {
+	tmpPath := path + ".vidsim-link"
+	var err error
+
+	if symbolic {
+		var target string
+
+		if target, err = filepath.Abs(keeper); err == nil {
+			err = os.Symlink(target, tmpPath)
+		}
+	} else {
+		err = os.Link(keeper, tmpPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if quarantined != "" {
+		if err = moveFile(path, quarantined); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+
+		if quarantined != "" {
+			moveFile(quarantined, path)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package processor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abelikoff/vidsim/state"
+)
+
+const reviewHelp = `Commands:
+  c        confirm the group as duplicates
+  k N      keep file N (confirms the group)
+  f N M    mark files N and M as a false positive match
+  u        mark all files in the group as false positive matches
+  s        skip the group
+  q        quit
+`
+
+// Walk through the groups from the last completed run, asking the reviewer what to do with each.
+// Decisions are saved to the state. Groups that were already reviewed are skipped unless
+// reviewAll is set. On a terminal this is done in a full-screen interface (see reviewtui.go),
+// otherwise with a line-oriented prompt.
+
+func (proc *Processor) Review(in io.Reader, out io.Writer, reviewAll bool) error {
+	if !proc.state.IsPersistent() {
+		return errors.New("reviewing requires persistent state")
+	}
+
+	groups, found := proc.state.GetLastGroups()
+
+	if !found || len(groups) == 0 {
+		fmt.Fprintln(out, "No groups to review (run 'vidsim process' first)")
+		return nil
+	}
+
+	var pending []int // indices of the groups to review
+
+	for ii, group := range groups {
+		if _, reviewed := proc.state.GetGroupDecision(group.Frames); !reviewed || reviewAll {
+			pending = append(pending, ii)
+		}
+	}
+
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "All groups are reviewed (use --all to review them again)")
+		return nil
+	}
+
+	if inFile, outFile, ok := terminalFiles(in, out); ok {
+		return proc.reviewInTerminal(inFile, outFile, groups, pending)
+	}
+
+	scanner := bufio.NewScanner(in)
+
+	for _, ii := range pending {
+		group := groups[ii]
+		decision, _ := proc.state.GetGroupDecision(group.Frames)
+		fmt.Fprintf(out, "\n%s\n", groupTitle(groups, ii))
+
+		for _, line := range proc.groupLines(&group, decision) {
+			fmt.Fprintln(out, line)
+		}
+
+		if !proc.reviewGroup(scanner, out, &group) {
+			break
+		}
+	}
+
+	return scanner.Err()
+}
+
+func groupTitle(groups []state.GroupRecord, index int) string {
+	return fmt.Sprintf("Group %d/%d (bucket %d, %d files)", index+1, len(groups), groups[index].Bucket, len(groups[index].Files))
+}
+
+// Lines describing a group: two per member (its path and details), followed by the scores and the
+// decision made (if any). Member lines are indented by two characters, leaving room for markers.
+
+func (proc *Processor) groupLines(group *state.GroupRecord, decision *state.GroupDecision) []string {
+	var lines []string
+
+	for ii, frameID := range group.Frames {
+		lines = append(lines,
+			fmt.Sprintf("  [%d] %s", ii+1, group.Files[ii]),
+			"      "+proc.fileDetails(frameID, group.Files[ii]))
+	}
+
+	var scores []string
+
+	for ii, frameID1 := range group.Frames {
+		for jj := ii + 1; jj < len(group.Frames); jj++ {
+			if info, found := proc.state.GetComparison(frameID1, group.Frames[jj]); found {
+				score := fmt.Sprintf("%d-%d: %.3f", ii+1, jj+1, info.Score)
+
+				if info.FalsePositive {
+					score += " (false positive)"
+				}
+
+				scores = append(scores, score)
+			}
+		}
+	}
+
+	if len(scores) > 0 {
+		lines = append(lines, "  Scores: "+strings.Join(scores, ", "))
+	}
+
+	if decision != nil {
+		status := "confirmed"
+
+		if keeper := indexOf(group.Frames, decision.Keeper); keeper >= 0 {
+			status = fmt.Sprintf("confirmed, keeping [%d]", keeper+1)
+		}
+
+		lines = append(lines, fmt.Sprintf("  Reviewed %s: %s", decision.Reviewed.Format("2006-01-02 15:04"), status))
+	}
+
+	return lines
+}
+
+// Size, duration, resolution and codec of a file
+
+func (proc *Processor) fileDetails(frameID int, path string) string {
+	var details []string
+
+	if info, err := os.Stat(path); err == nil {
+		details = append(details, formatSize(info.Size()), "modified "+info.ModTime().Format("2006-01-02 15:04"))
+	} else {
+		details = append(details, "file not found")
+	}
+
+	if metadata, found := proc.state.GetVideoMetadata(frameID); found {
+		if metadata.Duration > 0 {
+			details = append(details, (time.Duration(metadata.Duration) * time.Second).String())
+		}
+
+		if metadata.Width > 0 {
+			details = append(details, fmt.Sprintf("%dx%d", metadata.Width, metadata.Height))
+		}
+
+		if metadata.Codec != "" {
+			details = append(details, metadata.Codec)
+		}
+	}
+
+	return strings.Join(details, ", ")
+}
+
+// Prompt for commands until the group is done with. Returns false when the reviewer quits.
+
+func (proc *Processor) reviewGroup(scanner *bufio.Scanner, out io.Writer, group *state.GroupRecord) bool {
+	for {
+		fmt.Fprint(out, "Action (c, k N, f N M, u, s, q, ? for help): ")
+
+		if !scanner.Scan() {
+			return false
+		}
+
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		members, err := parseMembers(fields[1:], len(group.Frames))
+
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+
+		switch {
+		case fields[0] == "c" && len(members) == 0:
+			proc.confirmGroup(group, -1)
+			return true
+
+		case fields[0] == "k" && len(members) == 1:
+			proc.confirmGroup(group, members[0])
+			return true
+
+		case fields[0] == "f" && len(members) == 2 && members[0] != members[1]:
+			proc.state.UnmatchFrames(group.Frames[members[0]], group.Frames[members[1]], true)
+			fmt.Fprintf(out, "Marked [%d] and [%d] as a false positive\n", members[0]+1, members[1]+1)
+
+		case fields[0] == "u" && len(members) == 0:
+			proc.unmatchGroup(group)
+			return true
+
+		case fields[0] == "s" && len(members) == 0:
+			return true
+
+		case fields[0] == "q":
+			return false
+
+		default:
+			fmt.Fprint(out, reviewHelp)
+		}
+	}
+}
+
+// Confirm the group as duplicates, keeping the given member (none if negative)
+
+func (proc *Processor) confirmGroup(group *state.GroupRecord, keeper int) {
+	decision := &state.GroupDecision{Frames: group.Frames, Confirmed: true, Reviewed: time.Now()}
+
+	if keeper >= 0 {
+		decision.Keeper = group.Frames[keeper]
+	}
+
+	proc.state.SetGroupDecision(decision)
+}
+
+// Mark all members of the group as false positive matches of each other
+
+func (proc *Processor) unmatchGroup(group *state.GroupRecord) {
+	for ii, frameID1 := range group.Frames {
+		for _, frameID2 := range group.Frames[:ii] {
+			proc.state.UnmatchFrames(frameID1, frameID2, true)
+		}
+	}
+}
+
+// Convert 1-based member numbers into indices
+
+func parseMembers(args []string, numMembers int) ([]int, error) {
+	members := make([]int, len(args))
+
+	for ii, arg := range args {
+		member, err := strconv.Atoi(arg)
+
+		if err != nil || member < 1 || member > numMembers {
+			return nil, fmt.Errorf("bad file number: %s", arg)
+		}
+
+		members[ii] = member - 1
+	}
+
+	return members, nil
+}
+
+func indexOf(frames []int, frameID int) int {
+	for ii, other := range frames {
+		if other == frameID {
+			return ii
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,247 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/abelikoff/vidsim/state"
+	"golang.org/x/term"
+)
+
+// Full-screen review on a terminal: the members of a group are listed with their details, one of
+// them is selected with the cursor and single keys act on the group. The screen is drawn with
+// plain ANSI escape sequences while the terminal is in raw mode.
+
+const reviewKeys = "↑↓ select  space mark  enter keep  c confirm  f false positive pair  u all false positives  ←→ group  q quit"
+
+type reviewScreen struct {
+	proc    *Processor
+	groups  []state.GroupRecord
+	pending []int        // indices of the groups to review
+	current int          // index of the shown group in pending
+	cursor  int          // selected member
+	marked  map[int]bool // members marked as a false positive pair
+	message string       // result of the last action
+}
+
+// Input and output of the review if both are terminals
+
+func terminalFiles(in io.Reader, out io.Writer) (*os.File, *os.File, bool) {
+	inFile, ok := in.(*os.File)
+
+	if !ok || !term.IsTerminal(int(inFile.Fd())) {
+		return nil, nil, false
+	}
+
+	outFile, ok := out.(*os.File)
+
+	if !ok || !term.IsTerminal(int(outFile.Fd())) {
+		return nil, nil, false
+	}
+
+	return inFile, outFile, true
+}
+
+func (proc *Processor) reviewInTerminal(in, out *os.File, groups []state.GroupRecord, pending []int) error {
+	oldState, err := term.MakeRaw(int(in.Fd()))
+
+	if err != nil {
+		return err
+	}
+
+	defer term.Restore(int(in.Fd()), oldState)
+
+	// use the alternate screen with a hidden cursor, restoring both on exit
+
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	screen := &reviewScreen{proc: proc, groups: groups, pending: pending, marked: make(map[int]bool)}
+	buf := make([]byte, 16)
+
+	for {
+		width, height, err := term.GetSize(int(out.Fd()))
+
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+
+		fmt.Fprint(out, "\x1b[H\x1b[2J"+strings.Join(screen.render(width, height), "\r\n"))
+		n, err := in.Read(buf)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if screen.handleKey(parseKey(buf[:n])) {
+			return nil
+		}
+	}
+}
+
+// Name of a key read from a terminal in raw mode: arrows, enter and space are named, other keys
+// are returned as is
+
+func parseKey(input []byte) string {
+	switch {
+	case len(input) >= 3 && input[0] == 0x1b && input[1] == '[':
+		switch input[2] {
+		case 'A':
+			return "up"
+		case 'B':
+			return "down"
+		case 'C':
+			return "right"
+		case 'D':
+			return "left"
+		}
+
+		return ""
+
+	case len(input) == 1 && (input[0] == '\r' || input[0] == '\n'):
+		return "enter"
+
+	case len(input) == 1 && input[0] == ' ':
+		return "space"
+
+	case len(input) == 1 && input[0] == 3: // Ctrl-C
+		return "q"
+	}
+
+	return string(input)
+}
+
+// Act on a key. Returns true when the review is over.
+
+func (screen *reviewScreen) handleKey(key string) bool {
+	group := &screen.groups[screen.pending[screen.current]]
+	screen.message = ""
+
+	switch key {
+	case "up":
+		screen.cursor = max(screen.cursor-1, 0)
+
+	case "down":
+		screen.cursor = min(screen.cursor+1, len(group.Files)-1)
+
+	case "space":
+		screen.marked[screen.cursor] = !screen.marked[screen.cursor]
+
+	case "enter":
+		screen.proc.confirmGroup(group, screen.cursor)
+		return screen.showGroup(screen.current + 1)
+
+	case "c":
+		screen.proc.confirmGroup(group, -1)
+		return screen.showGroup(screen.current + 1)
+
+	case "f":
+		var pair []int
+
+		for ii := range group.Frames {
+			if screen.marked[ii] {
+				pair = append(pair, ii)
+			}
+		}
+
+		if len(pair) != 2 {
+			screen.message = "Mark exactly two files (with space) first"
+			break
+		}
+
+		screen.proc.state.UnmatchFrames(group.Frames[pair[0]], group.Frames[pair[1]], true)
+		screen.marked = make(map[int]bool)
+		screen.message = fmt.Sprintf("Marked [%d] and [%d] as a false positive", pair[0]+1, pair[1]+1)
+
+	case "u":
+		screen.proc.unmatchGroup(group)
+		return screen.showGroup(screen.current + 1)
+
+	case "right":
+		return screen.showGroup(screen.current + 1)
+
+	case "left":
+		screen.showGroup(max(screen.current-1, 0))
+
+	case "q":
+		return true
+	}
+
+	return false
+}
+
+// Switch to another group. Returns true past the last one.
+
+func (screen *reviewScreen) showGroup(current int) bool {
+	if current >= len(screen.pending) {
+		return true
+	}
+
+	screen.current = current
+	screen.cursor = 0
+	screen.marked = make(map[int]bool)
+	return false
+}
+
+// Lines of the screen: the group title, its members (scrolled to keep the selected one visible),
+// scores and decision, then the last message and the keys
+
+func (screen *reviewScreen) render(width, height int) []string {
+	index := screen.pending[screen.current]
+	group := &screen.groups[index]
+	decision, _ := screen.proc.state.GetGroupDecision(group.Frames)
+	body := screen.proc.groupLines(group, decision)
+
+	for ii := range group.Files {
+		line := []byte(body[2*ii])
+
+		if ii == screen.cursor {
+			line[0] = '>'
+		}
+
+		if screen.marked[ii] {
+			line[1] = '*'
+		}
+
+		body[2*ii] = string(line)
+	}
+
+	// scroll the body so that the selected member is visible
+
+	available := max(height-4, 2)
+	start := max(0, min(2*screen.cursor+2-available, len(body)-available))
+	body = body[start:min(start+available, len(body))]
+	lines := []string{truncateLine(groupTitle(screen.groups, index), width), ""}
+
+	for ii, line := range body {
+		line = truncateLine(line, width)
+
+		if start+ii == 2*screen.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+
+		lines = append(lines, line)
+	}
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+
+	return append(lines, truncateLine(screen.message, width), truncateLine(reviewKeys, width))
+}
+
+func truncateLine(line string, width int) string {
+	runes := []rune(line)
+
+	if len(runes) <= width {
+		return line
+	}
+
+	return string(runes[:max(width, 0)])
+}
@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Named roots of the collection (see state/roots.go)
+
+func (proc *Processor) ListRoots(out io.Writer, asJSON bool) error {
+	roots := proc.state.GetRoots()
+
+	if asJSON {
+		return writeJSON(out, roots)
+	}
+
+	for _, root := range roots {
+		fmt.Fprintf(out, "%s=%s\n", root.Name, root.Directory)
+	}
+
+	return nil
+}
+
+// Register a root given as NAME=DIR
+
+func (proc *Processor) AddRoot(spec string) error {
+	name, dir, found := strings.Cut(spec, "=")
+
+	if !found || dir == "" {
+		return fmt.Errorf("bad root '%s' (expected NAME=DIR)", spec)
+	}
+
+	numConverted, err := proc.state.AddRoot(name, dir)
+
+	if err != nil {
+		return err
+	}
+
+	proc.logger.Infof("Added root '%s', converted %d stored paths", name, numConverted)
+	return nil
+}
+
+func (proc *Processor) RemoveRoot(name string) error {
+	numConverted, err := proc.state.RemoveRoot(name)
+
+	if err != nil {
+		return err
+	}
+
+	proc.logger.Infof("Removed root '%s', converted %d stored paths", name, numConverted)
+	return nil
+}
@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Shell script: one commented out rm (or mv, when a quarantine directory is set) line per
+// redundant file, so the script can be reviewed and edited before running it. The keeper is
+// the one marked by the keeper policy (the first file of the group if none is used). Groups may
+// overlap (e.g. with cliques grouping), so as with resolving, a file kept in one group is not
+// removed in another and groups whose keeper is removed in an earlier group are skipped.
+
+type scriptReportWriter struct {
+	writer     io.Writer
+	quarantine string
+	groups     []string        // generated lines of each group
+	keepers    map[string]int  // path -> bucket the file is kept in
+	removed    map[string]bool // files removed in earlier groups
+}
+
+func newScriptReportWriter(proc *Processor, writer io.Writer) ReportWriter {
+	return &scriptReportWriter{
+		writer:     writer,
+		quarantine: proc.Quarantine,
+		keepers:    make(map[string]int),
+		removed:    make(map[string]bool),
+	}
+}
+
+func (rw *scriptReportWriter) WriteGroup(group *ReportGroup) error {
+	var lines strings.Builder
+	keeper := group.Keeper
+
+	if keeper == "" {
+		keeper = group.Files[0].Path
+	}
+
+	if rw.removed[keeper] {
+		fmt.Fprintf(&lines, "# bucket %d (score %.4f) skipped: %s is removed in an earlier group\n",
+			group.Bucket, group.Score, shellComment(keeper))
+		rw.groups = append(rw.groups, lines.String())
+		return nil
+	}
+
+	if _, found := rw.keepers[keeper]; !found {
+		rw.keepers[keeper] = group.Bucket
+	}
+
+	fmt.Fprintf(&lines, "# bucket %d (score %.4f), keeping %s\n", group.Bucket, group.Score, shellComment(keeper))
+
+	for _, file := range group.Files {
+		if file.Path == keeper {
+			continue
+		}
+
+		if bucket, found := rw.keepers[file.Path]; found {
+			fmt.Fprintf(&lines, "# kept in bucket %d: %s\n", bucket, shellComment(file.Path))
+			continue
+		}
+
+		if rw.removed[file.Path] {
+			fmt.Fprintf(&lines, "# removed in an earlier group: %s\n", shellComment(file.Path))
+			continue
+		}
+
+		// a line break in the name would end the comment, so such files are left out
+
+		if strings.ContainsAny(file.Path, "\n\r") {
+			fmt.Fprintf(&lines, "# skipped (the name contains a line break): %s\n", shellComment(file.Path))
+			continue
+		}
+
+		rw.removed[file.Path] = true
+
+		if rw.quarantine == "" {
+			fmt.Fprintf(&lines, "# rm -- %s\n", shellQuote(file.Path))
+			continue
+		}
+
+		target, err := quarantinePath(rw.quarantine, file.Path)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&lines, "# mkdir -p -- %s && mv -- %s %s\n",
+			shellQuote(filepath.Dir(target)), shellQuote(file.Path), shellQuote(target))
+	}
+
+	rw.groups = append(rw.groups, lines.String())
+	return nil
+}
+
+func (rw *scriptReportWriter) Finish(summary *ReportSummary) error {
+	writer := bufio.NewWriter(rw.writer)
+
+	fmt.Fprintf(writer, `#!/bin/sh
+#
+# Generated by vidsim on %s
+#
+# Tolerances: chrominance %g, proportion %g, duration %g
+# Sample positions: %v
+# Threshold: %g, grouping: %s, keeper policy: %s
+# Files: %d, comparisons: %d, groups: %d
+#
+# Review the commands below and uncomment the ones to run.
+
+set -eu
+`,
+		summary.Finished.Format(time.RFC3339),
+		summary.ChrTolerance, summary.PropTolerance, summary.DurationTolerance,
+		summary.SamplePositions,
+		summary.Threshold, summary.Grouping, orDefault(summary.KeeperPolicy, "first file"),
+		summary.NumFiles, summary.NumComparisons, summary.NumGroups)
+
+	for _, lines := range rw.groups {
+		fmt.Fprintf(writer, "\n%s", lines)
+	}
+
+	return writer.Flush()
+}
+
+// Quote a string for POSIX shell
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Path shown in a comment: quoted, with line breaks escaped
+
+func shellComment(s string) string {
+	if strings.ContainsAny(s, "\n\r") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return shellQuote(s)
+}
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
@@ -11,10 +11,17 @@ import (
 type StatsCollector struct {
 	NumFilesToProcess   int
 	NumFramesToGenerate int
+	NumFilesFiltered    int
 	NumFramesGenerated  int
 	NumTotalComparisons int
+	NumDurationSkips    int
+	NumIndexSkips       int
 	NumComparisonsMade  int
 	NumCacheHits        int
+	NumRestored         int
+	NumInvalidated      int
+	NumFilesModified    int
+	NumScoresDropped    int
 	NumMatches          int
 	NumFalsePositives   int
 	comparisonStartTime time.Time
@@ -93,17 +100,31 @@ SUMMARY
 =======
 Video files:         %10d
 Frames generated:    %10d  (%d%%)
+Filtered out:        %10d
+Skipped (duration):  %10d
+Skipped (index):     %10d
 Total comparisons:   %10d
 New comparisons:     %10d  (%d%%)
+Restored matches:    %10d
+Invalidated results: %10d
+Modified files:      %10d
+Dropped results:     %10d
 Total matches:       %10d
 False positives:     %10d
 `,
 		stats.NumFilesToProcess,
 		stats.NumFramesToGenerate,
 		genPercentage,
+		stats.NumFilesFiltered,
+		stats.NumDurationSkips,
+		stats.NumIndexSkips,
 		stats.NumTotalComparisons,
 		stats.NumTotalComparisons-stats.NumCacheHits,
 		compPercentage,
+		stats.NumRestored,
+		stats.NumInvalidated,
+		stats.NumFilesModified,
+		stats.NumScoresDropped,
 		stats.NumMatches,
 		stats.NumFalsePositives)
 }
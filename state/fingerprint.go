@@ -0,0 +1,236 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Files are identified by a content fingerprint (in addition to their paths) so that a moved or
+// renamed file can be recognized and keep its frame ID, frames and scores. The fingerprint is
+// a hash of the file size and a few chunks sampled across the file, which is cheap to compute
+// even for large videos.
+
+const (
+	fingerprintChunkSize = 64 * 1024
+	fingerprintNumChunks = 3
+)
+
+func fileFingerprint(path string) ([]byte, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	info, err := f.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, size)
+
+	if size <= fingerprintChunkSize*fingerprintNumChunks {
+		if _, err := io.Copy(hash, f); err != nil {
+			return nil, err
+		}
+
+		return hash.Sum(nil), nil
+	}
+
+	// chunks at the start, in the middle and at the end
+
+	chunk := make([]byte, fingerprintChunkSize)
+
+	for ii := range fingerprintNumChunks {
+		offset := (size - fingerprintChunkSize) * int64(ii) / (fingerprintNumChunks - 1)
+
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+
+		hash.Write(chunk)
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// Fingerprint record: frame ID followed by the last known path of the file
+
+func encodeFingerprintValue(frameID int, path string) []byte {
+	return append(encodeFrameValue(frameID), path...)
+}
+
+func decodeFingerprintValue(encoded []byte) (int, string) {
+	return decodeFrameValue(encoded[:8]), string(encoded[8:])
+}
+
+func encodeFingerprintKey(fingerprint []byte) []byte {
+	return append(append([]byte{}, fingerprintPrefix...), fingerprint...)
+}
+
+// Find the frame ID of a file that was moved from another path. A fingerprint match whose last
+// known path still holds a registered file is a copy, not a move, and gets no frame ID. Paths
+// are in the stored form (see roots.go).
+
+func (state *State) findMovedFile(txn *badger.Txn, path string, fingerprint []byte) (int, string, bool) {
+	item, err := txn.Get(encodeFingerprintKey(fingerprint))
+
+	if err != nil {
+		return 0, "", false
+	}
+
+	val, err := item.ValueCopy(nil)
+
+	if err != nil {
+		return 0, "", false
+	}
+
+	frameID, oldPath := decodeFingerprintValue(val)
+
+	if oldPath != path {
+		if _, err := os.Stat(state.realPath(oldPath)); err == nil {
+			if _, err := txn.Get(encodeFrameKey(oldPath)); err == nil {
+				return 0, "", false
+			}
+		}
+	}
+
+	return frameID, oldPath, true
+}
+
+// Record the fingerprint of a file (along with the reverse frame ID -> fingerprint mapping).
+// An existing fingerprint record is only replaced when it belongs to the same frame.
+
+func (state *State) setFingerprint(txn *badger.Txn, frameID int, path string, fingerprint []byte) error {
+	key := encodeFingerprintKey(fingerprint)
+
+	if item, err := txn.Get(key); err == nil {
+		val, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		if otherID, _ := decodeFingerprintValue(val); otherID != frameID {
+			return txn.Set(encodeFrameIDKey(frameFingerprintPrefix, frameID), fingerprint)
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	if err := txn.Set(key, encodeFingerprintValue(frameID, path)); err != nil {
+		return err
+	}
+
+	return txn.Set(encodeFrameIDKey(frameFingerprintPrefix, frameID), fingerprint)
+}
+
+// Replace the fingerprint of a file whose contents changed
+
+func (state *State) refreshFingerprint(txn *badger.Txn, frameID int, storedPath string) error {
+	if item, err := txn.Get(encodeFrameIDKey(frameFingerprintPrefix, frameID)); err == nil {
+		oldFingerprint, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		// the old record may belong to another copy of the file
+
+		if err = state.deleteFingerprintOf(txn, oldFingerprint, frameID); err != nil {
+			return err
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	fingerprint, err := fileFingerprint(state.realPath(storedPath))
+
+	if err != nil {
+		return txn.Delete(encodeFrameIDKey(frameFingerprintPrefix, frameID))
+	}
+
+	return state.setFingerprint(txn, frameID, storedPath, fingerprint)
+}
+
+func (state *State) deleteFingerprintOf(txn *badger.Txn, fingerprint []byte, frameID int) error {
+	key := encodeFingerprintKey(fingerprint)
+	item, err := txn.Get(key)
+
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	val, err := item.ValueCopy(nil)
+
+	if err != nil {
+		return err
+	}
+
+	if otherID, _ := decodeFingerprintValue(val); otherID != frameID {
+		return nil
+	}
+
+	return txn.Delete(key)
+}
+
+func hasFingerprint(txn *badger.Txn, frameID int) bool {
+	_, err := txn.Get(encodeFrameIDKey(frameFingerprintPrefix, frameID))
+	return err == nil
+}
+
+// Delete fingerprint records of frames that are not in the valid set
+
+func (state *State) deleteStaleFingerprints(validFrames map[int]bool) {
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(fingerprintPrefix); it.ValidForPrefix(fingerprintPrefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			if frameID, _ := decodeFingerprintValue(val); validFrames[frameID] {
+				continue
+			}
+
+			if err := wb.Delete(item.KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+
+	if err != nil {
+		state.logger.Errorf("deleteStaleFingerprints(): %s", err)
+	}
+
+	state.deleteStaleFrameRecords(frameFingerprintPrefix, validFrames)
+}
+
+var fingerprintPrefix = []byte("c:")      // fingerprint -> frame ID and last known path
+var frameFingerprintPrefix = []byte("h:") // frame ID -> fingerprint
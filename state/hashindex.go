@@ -0,0 +1,282 @@
+package state
+
+import (
+	"encoding/gob"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// Index of perceptual hashes of the sampled frames. There is a BK-tree per sample position
+// allowing us to find frames within a given Hamming distance without comparing all pairs.
+//
+// BK-trees don't support deletion, so when a frame's hashes change the old nodes are left in
+// place and filtered out during search. The trees are rebuilt once stale nodes start dominating.
+
+type hashIndex struct {
+	Trees    []*bkNode        // one tree per sample
+	Hashes   map[int][]uint64 // frame ID -> current hashes of its samples
+	NumStale int              // number of nodes not matching current hashes
+	modified bool
+}
+
+type bkNode struct {
+	FrameID  int
+	Hash     uint64
+	Children map[int]*bkNode // Hamming distance -> child
+}
+
+const hashIndexFile = "hashindex.gob"
+
+func newHashIndex() *hashIndex {
+	return &hashIndex{Hashes: make(map[int][]uint64)}
+}
+
+// Add (or update) hashes of the frame's samples in the index
+
+func (state *State) IndexFrame(frameID int, hashes []uint64) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	index := state.hashIndex
+	existing, found := index.Hashes[frameID]
+
+	if found && equalHashes(existing, hashes) {
+		return
+	}
+
+	index.NumStale += len(existing)
+	index.Hashes[frameID] = hashes
+	index.modified = true
+
+	for ii, hash := range hashes {
+		index.insert(ii, frameID, hash)
+	}
+}
+
+func (state *State) IsFrameIndexed(frameID int) bool {
+	state.mutex.RLock()
+	_, found := state.hashIndex.Hashes[frameID]
+	state.mutex.RUnlock()
+	return found
+}
+
+// Find frames having at least one sample within a given Hamming distance from
+// the corresponding sample of the query
+
+func (state *State) FindSimilarFrames(hashes []uint64, radius int) []int {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	index := state.hashIndex
+	found := make(map[int]bool)
+	var result []int
+
+	for ii, hash := range hashes {
+		if ii >= len(index.Trees) {
+			break
+		}
+
+		index.Trees[ii].search(hash, radius, func(node *bkNode) {
+			current, live := index.Hashes[node.FrameID]
+
+			if !live || len(current) <= ii || current[ii] != node.Hash || found[node.FrameID] {
+				return
+			}
+
+			found[node.FrameID] = true
+			result = append(result, node.FrameID)
+		})
+	}
+
+	return result
+}
+
+// Remove frames not in the valid set from the index
+
+func (state *State) PruneHashIndex(validFrames map[int]bool) int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	numDeleted := 0
+
+	for frameID, hashes := range state.hashIndex.Hashes {
+		if !validFrames[frameID] {
+			delete(state.hashIndex.Hashes, frameID)
+			state.hashIndex.NumStale += len(hashes)
+			state.hashIndex.modified = true
+			numDeleted++
+		}
+	}
+
+	return numDeleted
+}
+
+// Save the index into the state directory (only if it changed)
+
+func (state *State) SaveHashIndex() error {
+	if !state.persistent {
+		return nil
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	index := state.hashIndex
+
+	if !index.modified {
+		return nil
+	}
+
+	if index.NumStale > index.numLiveNodes() {
+		state.logger.Debugf("Rebuilding hash index (%d stale nodes)", index.NumStale)
+		index.rebuild()
+	}
+
+	// Write into a temporary file first so that a crash doesn't leave a broken index behind
+
+	indexFile := filepath.Join(state.dataDirectory, hashIndexFile)
+	tmpFile := indexFile + ".tmp"
+	f, err := os.Create(tmpFile)
+
+	if err != nil {
+		return err
+	}
+
+	if err = gob.NewEncoder(f).Encode(index); err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err = os.Rename(tmpFile, indexFile); err != nil {
+		return err
+	}
+
+	index.modified = false
+	return nil
+}
+
+func (state *State) loadHashIndex() {
+	state.hashIndex = newHashIndex()
+	f, err := os.Open(filepath.Join(state.dataDirectory, hashIndexFile))
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			state.logger.Warningf("Cannot open hash index: %s", err)
+		}
+
+		return
+	}
+
+	defer f.Close()
+	index := newHashIndex()
+
+	if err = gob.NewDecoder(f).Decode(index); err != nil {
+		state.logger.Warningf("Discarding corrupt hash index: %s", err)
+		return
+	}
+
+	if index.Hashes == nil {
+		index.Hashes = make(map[int][]uint64)
+	}
+
+	state.hashIndex = index
+}
+
+func (index *hashIndex) insert(sample int, frameID int, hash uint64) {
+	for len(index.Trees) <= sample {
+		index.Trees = append(index.Trees, nil)
+	}
+
+	node := &bkNode{FrameID: frameID, Hash: hash}
+
+	if index.Trees[sample] == nil {
+		index.Trees[sample] = node
+		return
+	}
+
+	index.Trees[sample].add(node)
+}
+
+func (index *hashIndex) rebuild() {
+	index.Trees = nil
+	index.NumStale = 0
+
+	for frameID, hashes := range index.Hashes {
+		for ii, hash := range hashes {
+			index.insert(ii, frameID, hash)
+		}
+	}
+}
+
+func (index *hashIndex) numLiveNodes() int {
+	numNodes := 0
+
+	for _, hashes := range index.Hashes {
+		numNodes += len(hashes)
+	}
+
+	return numNodes
+}
+
+func (node *bkNode) add(newNode *bkNode) {
+	for {
+		distance := hammingDistance(node.Hash, newNode.Hash)
+		child, found := node.Children[distance]
+
+		if !found {
+			if node.Children == nil {
+				node.Children = make(map[int]*bkNode)
+			}
+
+			node.Children[distance] = newNode
+			return
+		}
+
+		node = child
+	}
+}
+
+func (node *bkNode) search(hash uint64, radius int, visit func(*bkNode)) {
+	if node == nil {
+		return
+	}
+
+	distance := hammingDistance(node.Hash, hash)
+
+	if distance <= radius {
+		visit(node)
+	}
+
+	// By triangle inequality, matches can only be found in subtrees within the radius
+
+	for childDistance, child := range node.Children {
+		if childDistance >= distance-radius && childDistance <= distance+radius {
+			child.search(hash, radius, visit)
+		}
+	}
+}
+
+func hammingDistance(hash1, hash2 uint64) int {
+	return bits.OnesCount64(hash1 ^ hash2)
+}
+
+func equalHashes(hashes1, hashes2 []uint64) bool {
+	if len(hashes1) != len(hashes2) {
+		return false
+	}
+
+	for ii := range hashes1 {
+		if hashes1[ii] != hashes2[ii] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/vitali-fedulov/images4"
+)
+
+// Icons (compact perceptual representations) of the sampled frames, one per sample
+
+func (state *State) GetIcons(frameID int) ([]images4.IconT, bool) {
+	if state.persistent {
+		return state.getIconsPersistent(frameID)
+	}
+
+	state.mutex.RLock()
+	icons, found := state.icons[frameID]
+	state.mutex.RUnlock()
+	return icons, found
+}
+
+func (state *State) SetIcons(frameID int, icons []images4.IconT) {
+	if state.persistent {
+		state.setIconsPersistent(frameID, icons)
+		return
+	}
+
+	state.mutex.Lock()
+	state.icons[frameID] = icons
+	state.mutex.Unlock()
+}
+
+func (state *State) getIconsPersistent(frameID int) ([]images4.IconT, bool) {
+	var icons []images4.IconT
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeFrameIDKey(iconPrefix, frameID))
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			icons, err = decodeIcons(val)
+			return err
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("getIconsPersistent(%d): %s", frameID, err)
+		}
+
+		return nil, false
+	}
+
+	return icons, true
+}
+
+func (state *State) setIconsPersistent(frameID int, icons []images4.IconT) {
+	err := state.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(encodeFrameIDKey(iconPrefix, frameID), encodeIcons(icons))
+	})
+
+	if err != nil {
+		state.logger.Errorf("setIconsPersistent(%d): %s", frameID, err)
+	}
+}
+
+var iconPrefix = []byte("i:")
+
+// Each icon is encoded as image width and height (uint32 each), the number of pixel
+// values (uint32) followed by the pixel values (uint16 each)
+
+func encodeIcons(icons []images4.IconT) []byte {
+	size := 0
+
+	for _, icon := range icons {
+		size += 12 + 2*len(icon.Pixels)
+	}
+
+	b := make([]byte, size)
+	offset := 0
+
+	for _, icon := range icons {
+		binary.BigEndian.PutUint32(b[offset:], uint32(icon.ImgSize.X))
+		binary.BigEndian.PutUint32(b[offset+4:], uint32(icon.ImgSize.Y))
+		binary.BigEndian.PutUint32(b[offset+8:], uint32(len(icon.Pixels)))
+		offset += 12
+
+		for _, pixel := range icon.Pixels {
+			binary.BigEndian.PutUint16(b[offset:], pixel)
+			offset += 2
+		}
+	}
+
+	return b
+}
+
+func decodeIcons(encoded []byte) ([]images4.IconT, error) {
+	var icons []images4.IconT
+	offset := 0
+
+	for offset < len(encoded) {
+		if len(encoded)-offset < 12 {
+			return nil, errors.New("truncated icon header")
+		}
+
+		var icon images4.IconT
+		icon.ImgSize = image.Point{
+			X: int(binary.BigEndian.Uint32(encoded[offset:])),
+			Y: int(binary.BigEndian.Uint32(encoded[offset+4:])),
+		}
+		numPixels := int(binary.BigEndian.Uint32(encoded[offset+8:]))
+		offset += 12
+
+		if len(encoded)-offset < 2*numPixels {
+			return nil, errors.New("truncated icon data")
+		}
+
+		icon.Pixels = make([]uint16, numPixels)
+
+		for ii := range numPixels {
+			icon.Pixels[ii] = binary.BigEndian.Uint16(encoded[offset:])
+			offset += 2
+		}
+
+		icons = append(icons, icon)
+	}
+
+	return icons, nil
+}
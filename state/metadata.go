@@ -0,0 +1,86 @@
+package state
+
+import (
+	"encoding/json"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Video properties obtained by probing the file
+
+type VideoMetadata struct {
+	Duration  float64 `json:"duration"`   // duration in seconds (0 if unknown)
+	Width     int     `json:"width"`      // frame width in pixels
+	Height    int     `json:"height"`     // frame height in pixels
+	Codec     string  `json:"codec"`      // video codec name
+	FrameRate float64 `json:"frame_rate"` // frames per second
+	Rotation  int     `json:"rotation"`   // display rotation in degrees
+	BitRate   int64   `json:"bit_rate"`   // overall bit rate (bits/s)
+	Container string  `json:"container"`  // container format name(s)
+}
+
+func (state *State) GetVideoMetadata(frameID int) (*VideoMetadata, bool) {
+	if state.persistent {
+		return state.getVideoMetadataPersistent(frameID)
+	}
+
+	state.mutex.RLock()
+	metadata, found := state.metadata[frameID]
+	state.mutex.RUnlock()
+	return metadata, found
+}
+
+func (state *State) SetVideoMetadata(frameID int, metadata *VideoMetadata) {
+	if state.persistent {
+		state.setVideoMetadataPersistent(frameID, metadata)
+		return
+	}
+
+	state.mutex.Lock()
+	state.metadata[frameID] = metadata
+	state.mutex.Unlock()
+}
+
+func (state *State) getVideoMetadataPersistent(frameID int) (*VideoMetadata, bool) {
+	metadata := new(VideoMetadata)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeFrameIDKey(metadataPrefix, frameID))
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, metadata)
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("getVideoMetadataPersistent(%d): %s", frameID, err)
+		}
+
+		return nil, false
+	}
+
+	return metadata, true
+}
+
+func (state *State) setVideoMetadataPersistent(frameID int, metadata *VideoMetadata) {
+	err := state.db.Update(func(txn *badger.Txn) error {
+		val, err := json.Marshal(metadata)
+
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(encodeFrameIDKey(metadataPrefix, frameID), val)
+	})
+
+	if err != nil {
+		state.logger.Errorf("setVideoMetadataPersistent(%d): %s", frameID, err)
+	}
+}
+
+var metadataPrefix = []byte("m:")
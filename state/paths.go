@@ -0,0 +1,331 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Rename registered files (old path -> new path). Renames are done in a single transaction when
+// they fit in one, so either all paths are renamed or none. Otherwise they are done in batches (see
+// renamePaths()).
+
+func (state *State) RenamePaths(renames map[string]string) error {
+	if !state.persistent {
+		return errors.New("renaming paths requires persistent state")
+	}
+
+	storedRenames := make(map[string]string, len(renames))
+
+	for oldPath, newPath := range renames {
+		storedRenames[state.storedPath(oldPath)] = state.storedPath(newPath)
+	}
+
+	return state.renamePaths(storedRenames, nil)
+}
+
+// Rename stored paths, along with other changes made by update (if not nil) in the same
+// transaction. Renames that don't fit in a single transaction are saved as pending first, then
+// the update is committed along with a mark that the pending renames are complete, and the renames
+// are done a batch at a time (each batch removing its pending renames in the same transaction).
+// Interrupted renames are completed the next time the state is opened (see resumeRenamePaths()).
+
+func (state *State) renamePaths(renames map[string]string, update func(txn *badger.Txn) error) error {
+	err := state.db.Update(func(txn *badger.Txn) error {
+		if update != nil {
+			if err := update(txn); err != nil {
+				return err
+			}
+		}
+
+		return renameStoredPaths(txn, renames)
+	})
+
+	if err != badger.ErrTxnTooBig {
+		return err
+	}
+
+	// batches can't swap paths, as the path being renamed away may be in a later batch
+
+	for _, newPath := range renames {
+		if _, found := renames[newPath]; found {
+			return fmt.Errorf("too many paths to rename in a single transaction, and '%s' is both renamed and a new path", newPath)
+		}
+	}
+
+	state.logger.Infof("Too many paths to rename in a single transaction, renaming in batches of %d", renameBatchSize)
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for oldPath, newPath := range renames {
+		if err := wb.Set(append(append([]byte{}, pendingRenamePrefix...), oldPath...), []byte(newPath)); err != nil {
+			return err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		state.discardPendingRenames()
+		return err
+	}
+
+	err = state.db.Update(func(txn *badger.Txn) error {
+		if update != nil {
+			if err := update(txn); err != nil {
+				return err
+			}
+		}
+
+		return txn.Set(pendingRenamesKey, []byte{})
+	})
+
+	if err != nil {
+		state.discardPendingRenames()
+		return err
+	}
+
+	return state.renamePendingPaths()
+}
+
+// Complete renames interrupted while being done in batches. This is done when the state is
+// opened, before anything else can register files under the old or new paths. Pending renames
+// without the mark of being complete were interrupted while being saved and are discarded.
+
+func (state *State) resumeRenamePaths() error {
+	numPending := state.numPendingRenames()
+	complete := true
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(pendingRenamesKey)
+
+		if err == badger.ErrKeyNotFound {
+			complete = false
+			return nil
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !complete {
+		if numPending > 0 {
+			state.logger.Warnf("Discarding %d incompletely saved renames of an interrupted run", numPending)
+			return state.discardPendingRenames()
+		}
+
+		return nil
+	}
+
+	if numPending > 0 {
+		state.logger.Infof("Completing an interrupted rename of %d paths", numPending)
+	}
+
+	return state.renamePendingPaths()
+}
+
+func (state *State) discardPendingRenames() error {
+	return state.db.DropPrefix(pendingRenamePrefix)
+}
+
+func (state *State) numPendingRenames() int {
+	numPending := 0
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(pendingRenamePrefix); it.ValidForPrefix(pendingRenamePrefix); it.Next() {
+			numPending++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		state.logger.Errorf("numPendingRenames(): %s", err)
+	}
+
+	return numPending
+}
+
+// Rename the pending paths a batch at a time, each batch in its own transaction
+
+func (state *State) renamePendingPaths() error {
+	for {
+		batch := make(map[string]string, renameBatchSize)
+
+		err := state.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+
+			for it.Seek(pendingRenamePrefix); it.ValidForPrefix(pendingRenamePrefix) && len(batch) < renameBatchSize; it.Next() {
+				item := it.Item()
+				newPath, err := item.ValueCopy(nil)
+
+				if err != nil {
+					return err
+				}
+
+				batch[string(item.Key()[len(pendingRenamePrefix):])] = string(newPath)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(batch) == 0 {
+			return state.db.Update(func(txn *badger.Txn) error {
+				return txn.Delete(pendingRenamesKey)
+			})
+		}
+
+		err = state.db.Update(func(txn *badger.Txn) error {
+			for oldPath := range batch {
+				if err := txn.Delete(append(append([]byte{}, pendingRenamePrefix...), oldPath...)); err != nil {
+					return err
+				}
+			}
+
+			return renameStoredPaths(txn, batch)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Besides the file records, renaming updates the last known paths of fingerprints and the files
+// of the last run's groups
+
+func renameStoredPaths(txn *badger.Txn, renames map[string]string) error {
+	values := make(map[string][]byte, len(renames))
+
+	// delete all old records first, so that paths can be swapped
+
+	for oldPath := range renames {
+		key := encodeFrameKey(oldPath)
+		item, err := txn.Get(key)
+
+		if err != nil {
+			return fmt.Errorf("'%s': %w", oldPath, err)
+		}
+
+		if values[oldPath], err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	for oldPath, newPath := range renames {
+		key := encodeFrameKey(newPath)
+
+		if _, err := txn.Get(key); err == nil {
+			return fmt.Errorf("'%s' is already registered", newPath)
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		if err := txn.Set(key, values[oldPath]); err != nil {
+			return err
+		}
+	}
+
+	if err := renameFingerprintPaths(txn, renames); err != nil {
+		return err
+	}
+
+	return renameLastGroupFiles(txn, renames)
+}
+
+func renameFingerprintPaths(txn *badger.Txn, renames map[string]string) error {
+	updates := make(map[string][]byte)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+
+	for it.Seek(fingerprintPrefix); it.ValidForPrefix(fingerprintPrefix); it.Next() {
+		item := it.Item()
+		val, err := item.ValueCopy(nil)
+
+		if err != nil {
+			it.Close()
+			return err
+		}
+
+		frameID, path := decodeFingerprintValue(val)
+
+		if newPath, found := renames[path]; found {
+			updates[string(item.KeyCopy(nil))] = encodeFingerprintValue(frameID, newPath)
+		}
+	}
+
+	it.Close()
+
+	for key, val := range updates {
+		if err := txn.Set([]byte(key), val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renameLastGroupFiles(txn *badger.Txn, renames map[string]string) error {
+	item, err := txn.Get(lastGroupsKey)
+
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var groups []GroupRecord
+
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &groups)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for ii := range groups {
+		group := &groups[ii]
+
+		for jj, path := range group.Files {
+			if newPath, found := renames[path]; found {
+				group.Files[jj] = newPath
+			}
+		}
+
+		if newPath, found := renames[group.Keeper]; found {
+			group.Keeper = newPath
+		}
+	}
+
+	val, err := json.Marshal(groups)
+
+	if err != nil {
+		return err
+	}
+
+	return txn.Set(lastGroupsKey, val)
+}
+
+const renameBatchSize = 1000
+
+var pendingRenamePrefix = []byte("w:")      // old stored path -> new stored path, for batched renames
+var pendingRenamesKey = []byte("l:renames") // present while pending renames are complete and being done
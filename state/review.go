@@ -0,0 +1,219 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Group of similar files produced by the last completed run
+
+type GroupRecord struct {
+	Bucket int      `json:"bucket"`
+	Frames []int    `json:"frames"`           // sorted frame IDs
+	Files  []string `json:"files"`            // paths of the files (in the same order)
+	Keeper string   `json:"keeper,omitempty"` // file picked by the keeper policy (if any)
+}
+
+// Reviewer's decision about a group of similar files
+
+type GroupDecision struct {
+	Frames    []int     `json:"frames"`           // group members at the time of the review (sorted)
+	Confirmed bool      `json:"confirmed"`        // members are confirmed duplicates
+	Keeper    int       `json:"keeper,omitempty"` // frame ID of the file to keep (0 if not chosen)
+	Reviewed  time.Time `json:"reviewed"`
+}
+
+func (state *State) GetLastGroups() ([]GroupRecord, bool) {
+	if !state.persistent {
+		return nil, false
+	}
+
+	var groups []GroupRecord
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(lastGroupsKey)
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &groups)
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("GetLastGroups(): %s", err)
+		}
+
+		return nil, false
+	}
+
+	return convertGroupPaths(groups, state.realPath), true
+}
+
+func (state *State) SetLastGroups(groups []GroupRecord) {
+	if !state.persistent {
+		return
+	}
+
+	err := state.db.Update(func(txn *badger.Txn) error {
+		val, err := json.Marshal(convertGroupPaths(groups, state.storedPath))
+
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(lastGroupsKey, val)
+	})
+
+	if err != nil {
+		state.logger.Errorf("SetLastGroups(): %s", err)
+	}
+}
+
+// Copy of groups with paths converted between the actual and the stored form (see roots.go)
+
+func convertGroupPaths(groups []GroupRecord, convert func(string) string) []GroupRecord {
+	converted := make([]GroupRecord, len(groups))
+
+	for ii, group := range groups {
+		converted[ii] = group
+		converted[ii].Files = make([]string, len(group.Files))
+
+		for jj, path := range group.Files {
+			converted[ii].Files[jj] = convert(path)
+		}
+
+		if group.Keeper != "" {
+			converted[ii].Keeper = convert(group.Keeper)
+		}
+	}
+
+	return converted
+}
+
+// Decisions are keyed by all (sorted) members of the group, since overlapping groups (e.g. cliques)
+// may share some of them. A decision is thus only found when the group still has exactly the
+// same members.
+
+func (state *State) GetGroupDecision(frames []int) (*GroupDecision, bool) {
+	if !state.persistent || len(frames) == 0 {
+		return nil, false
+	}
+
+	decision := new(GroupDecision)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeDecisionKey(frames))
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, decision)
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("GetGroupDecision(): %s", err)
+		}
+
+		return nil, false
+	}
+
+	return decision, true
+}
+
+func (state *State) SetGroupDecision(decision *GroupDecision) {
+	if !state.persistent || len(decision.Frames) == 0 {
+		return
+	}
+
+	slices.Sort(decision.Frames)
+
+	err := state.db.Update(func(txn *badger.Txn) error {
+		val, err := json.Marshal(decision)
+
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(encodeDecisionKey(decision.Frames), val)
+	})
+
+	if err != nil {
+		state.logger.Errorf("SetGroupDecision(): %s", err)
+	}
+}
+
+// Delete decisions about groups with members that no longer exist
+
+func (state *State) deleteStaleDecisions(validFrames map[int]bool) {
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(decisionPrefix); it.ValidForPrefix(decisionPrefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+
+			if !slices.ContainsFunc(decodeDecisionKey(key), func(frameID int) bool { return !validFrames[frameID] }) {
+				continue
+			}
+
+			if err := wb.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+
+	if err != nil {
+		state.logger.Errorf("deleteStaleDecisions(): %s", err)
+	}
+}
+
+// Decision key: prefix followed by the sorted frame IDs of the group members
+
+func encodeDecisionKey(frames []int) []byte {
+	members := slices.Clone(frames)
+	slices.Sort(members)
+	key := make([]byte, len(decisionPrefix), len(decisionPrefix)+8*len(members))
+	copy(key, decisionPrefix)
+
+	for _, frameID := range members {
+		key = binary.BigEndian.AppendUint64(key, uint64(frameID))
+	}
+
+	return key
+}
+
+func decodeDecisionKey(encoded []byte) []int {
+	var frames []int
+
+	for ii := len(decisionPrefix); ii+8 <= len(encoded); ii += 8 {
+		frames = append(frames, int(binary.BigEndian.Uint64(encoded[ii:])))
+	}
+
+	return frames
+}
+
+var decisionPrefix = []byte("r:")
+var lastGroupsKey = []byte("l:groups")
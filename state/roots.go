@@ -0,0 +1,231 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Named roots of the collection (e.g. movies=/srv/media/movies). Files under a root are stored
+// as "root:relative/path", so their records don't depend on the directory vidsim is run from and
+// survive moving the root elsewhere. Paths are converted at the boundary: methods of State take
+// and return actual paths, while the records hold stored ones.
+
+type Root struct {
+	Name      string `json:"name"`
+	Directory string `json:"directory"` // absolute path
+}
+
+var rootNameRx = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]+$`)
+
+func (state *State) GetRoots() []Root {
+	roots := make([]Root, 0, len(state.roots))
+
+	for name, dir := range state.roots {
+		roots = append(roots, Root{Name: name, Directory: dir})
+	}
+
+	sort.Slice(roots, func(ii, jj int) bool {
+		return roots[ii].Name < roots[jj].Name
+	})
+
+	return roots
+}
+
+// Register a root. Files already stored under its directory (including those stored relative to
+// an enclosing root) are converted to paths relative to it (relative paths are resolved against
+// the current directory), in batches on large collections (see renamePaths()). Returns the number
+// of converted paths.
+
+func (state *State) AddRoot(name, dir string) (int, error) {
+	if !state.persistent {
+		return 0, errors.New("roots require persistent state")
+	}
+
+	if !rootNameRx.MatchString(name) {
+		return 0, fmt.Errorf("bad root name '%s' (use at least two letters, digits, '_', '.' or '-')", name)
+	}
+
+	if _, found := state.roots[name]; found {
+		return 0, fmt.Errorf("root '%s' already exists", name)
+	}
+
+	dir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return 0, err
+	}
+
+	realPaths := state.realPaths()
+	state.roots[name] = dir
+	renames := state.rootRenames(realPaths)
+
+	err = state.renamePaths(renames, func(txn *badger.Txn) error {
+		return txn.Set(append(append([]byte{}, rootPrefix...), name...), []byte(dir))
+	})
+
+	if err != nil {
+		delete(state.roots, name)
+		return 0, err
+	}
+
+	return len(renames), nil
+}
+
+// Unregister a root, converting paths of the files under it to be relative to an enclosing root
+// (if any) or absolute. Returns the number of converted paths.
+
+func (state *State) RemoveRoot(name string) (int, error) {
+	dir, found := state.roots[name]
+
+	if !found {
+		return 0, fmt.Errorf("unknown root '%s'", name)
+	}
+
+	realPaths := state.realPaths()
+	delete(state.roots, name)
+	renames := state.rootRenames(realPaths)
+
+	err := state.renamePaths(renames, func(txn *badger.Txn) error {
+		return txn.Delete(append(append([]byte{}, rootPrefix...), name...))
+	})
+
+	if err != nil {
+		state.roots[name] = dir
+		return 0, err
+	}
+
+	return len(renames), nil
+}
+
+// Actual paths of all registered files (stored path -> actual path) under the current roots
+
+func (state *State) realPaths() map[string]string {
+	realPaths := make(map[string]string)
+
+	for _, path := range state.storedPaths() {
+		realPaths[path] = state.realPath(path)
+	}
+
+	return realPaths
+}
+
+// Renames of stored paths whose stored form differs under the current roots
+
+func (state *State) rootRenames(realPaths map[string]string) map[string]string {
+	renames := make(map[string]string)
+
+	for path, realPath := range realPaths {
+		if newPath := state.storedPath(realPath); newPath != path {
+			renames[path] = newPath
+		}
+	}
+
+	return renames
+}
+
+func (state *State) loadRoots() error {
+	return state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(rootPrefix); it.ValidForPrefix(rootPrefix); it.Next() {
+			item := it.Item()
+			dir, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			state.roots[string(item.Key()[len(rootPrefix):])] = string(dir)
+		}
+
+		return nil
+	})
+}
+
+// Path as stored in the records: relative to the innermost root containing the file, or as is
+// if there is none. Paths already in the stored form are returned unchanged.
+
+func (state *State) storedPath(path string) string {
+	if len(state.roots) == 0 {
+		return path
+	}
+
+	if rootName, _, found := strings.Cut(path, ":"); found && state.roots[rootName] != "" {
+		return path
+	}
+
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return path
+	}
+
+	stored, rootLength := path, 0
+
+	for name, dir := range state.roots {
+		rel, err := filepath.Rel(dir, absPath)
+
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if len(dir) > rootLength {
+			stored, rootLength = name+":"+filepath.ToSlash(rel), len(dir)
+		}
+	}
+
+	return stored
+}
+
+// Actual path of a file from its stored path
+
+func (state *State) realPath(stored string) string {
+	rootName, rel, found := strings.Cut(stored, ":")
+
+	if !found {
+		return stored
+	}
+
+	dir, found := state.roots[rootName]
+
+	if !found {
+		return stored
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(rel))
+}
+
+// Stored paths of all registered files
+
+func (state *State) storedPaths() []string {
+	var paths []string
+	prefix := []byte(framePrefix)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			paths = append(paths, decodeFrameKey(it.Item().Key()))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		state.logger.Errorf("storedPaths(): %s", err)
+	}
+
+	return paths
+}
+
+var rootPrefix = []byte("o:") // root name -> directory
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+var testRoots = map[string]string{
+	"media":  "/srv/media",
+	"movies": "/srv/media/movies",
+}
+
+func TestStoredPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/srv/media/a.mp4", "media:a.mp4"},
+		{"/srv/media/shows/b.mp4", "media:shows/b.mp4"},
+		{"/srv/media/movies/c.mp4", "movies:c.mp4"},         // innermost root
+		{"/srv/media/moviesx/d.mp4", "media:moviesx/d.mp4"}, // not under movies
+		{"/srv/mediax/e.mp4", "/srv/mediax/e.mp4"},          // not under media
+		{"/srv/media", "media:."},
+		{"/srv/media/movies/../f.mp4", "media:f.mp4"},
+		{"movies:g.mp4", "movies:g.mp4"}, // already stored
+		{"other:h.mp4", "other:h.mp4"},   // unknown root, kept as a relative path
+	}
+
+	state := MakeState()
+	state.roots = maps.Clone(testRoots)
+
+	for _, tt := range tests {
+		if got := state.storedPath(tt.path); got != tt.want {
+			t.Errorf("storedPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRealPath(t *testing.T) {
+	tests := []struct {
+		stored   string
+		want     string
+		restored string // stored path of the result
+	}{
+		{"media:a.mp4", "/srv/media/a.mp4", "media:a.mp4"},
+		{"media:shows/b.mp4", "/srv/media/shows/b.mp4", "media:shows/b.mp4"},
+		{"movies:c.mp4", "/srv/media/movies/c.mp4", "movies:c.mp4"},
+		{"media:movies/c.mp4", "/srv/media/movies/c.mp4", "movies:c.mp4"}, // stored before adding movies
+		{"/srv/mediax/e.mp4", "/srv/mediax/e.mp4", "/srv/mediax/e.mp4"},
+		{"other:h.mp4", "other:h.mp4", "other:h.mp4"},
+	}
+
+	state := MakeState()
+	state.roots = maps.Clone(testRoots)
+
+	for _, tt := range tests {
+		got := state.realPath(tt.stored)
+
+		if got != tt.want {
+			t.Errorf("realPath(%q) = %q, want %q", tt.stored, got, tt.want)
+		}
+
+		if restored := state.storedPath(got); restored != tt.restored {
+			t.Errorf("storedPath(%q) = %q, want %q", got, restored, tt.restored)
+		}
+	}
+}
+
+// Adding and removing nested roots converts the stored paths of the files under them
+
+func TestNestedRoots(t *testing.T) {
+	files := []string{"/srv/media/a.mp4", "/srv/media/movies/c.mp4", "/srv/mediax/e.mp4"}
+
+	steps := []struct {
+		add, remove string // root to add or remove
+		numRenamed  int
+		want        []string // sorted stored paths after the step
+	}{
+		{
+			add:        "movies",
+			numRenamed: 1,
+			want:       []string{"/srv/media/a.mp4", "/srv/mediax/e.mp4", "movies:c.mp4"},
+		},
+		{
+			add:        "media",
+			numRenamed: 1,
+			want:       []string{"/srv/mediax/e.mp4", "media:a.mp4", "movies:c.mp4"},
+		},
+		{
+			remove:     "movies",
+			numRenamed: 1,
+			want:       []string{"/srv/mediax/e.mp4", "media:a.mp4", "media:movies/c.mp4"},
+		},
+		{
+			add:        "movies",
+			numRenamed: 1,
+			want:       []string{"/srv/mediax/e.mp4", "media:a.mp4", "movies:c.mp4"},
+		},
+		{
+			remove:     "media",
+			numRenamed: 1,
+			want:       []string{"/srv/media/a.mp4", "/srv/mediax/e.mp4", "movies:c.mp4"},
+		},
+		{
+			remove:     "movies",
+			numRenamed: 1,
+			want:       []string{"/srv/media/a.mp4", "/srv/media/movies/c.mp4", "/srv/mediax/e.mp4"},
+		},
+	}
+
+	state := newTestState(t)
+
+	for _, path := range files {
+		if _, found := state.RegisterFile(path); found {
+			t.Fatalf("%s registered twice", path)
+		}
+	}
+
+	for ii, step := range steps {
+		var numRenamed int
+		var err error
+
+		if step.add != "" {
+			numRenamed, err = state.AddRoot(step.add, testRoots[step.add])
+		} else {
+			numRenamed, err = state.RemoveRoot(step.remove)
+		}
+
+		if err != nil {
+			t.Fatalf("step %d: %s", ii, err)
+		}
+
+		if numRenamed != step.numRenamed {
+			t.Errorf("step %d: renamed %d paths, want %d", ii, numRenamed, step.numRenamed)
+		}
+
+		got := state.storedPaths()
+		slices.Sort(got)
+
+		if !slices.Equal(got, step.want) {
+			t.Errorf("step %d: stored paths %v, want %v", ii, got, step.want)
+		}
+
+		for _, path := range files {
+			if _, found := state.GetframeID(path); !found {
+				t.Errorf("step %d: %s is no longer registered", ii, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Information about the last completed processing run
+
+type RunInfo struct {
+	Scope    string    `json:"scope"`    // signature of parameters determining which pairs were compared
+	Finished time.Time `json:"finished"` // when the run was completed
+}
+
+// Frames are marked as new when their samples are (re)generated, and the marks are cleared
+// once a processing run involving them completes. This lets incremental runs only compare
+// new frames against the rest.
+
+func (state *State) MarkFrameNew(frameID int) {
+	if state.persistent {
+		err := state.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(encodeFrameIDKey(newFramePrefix, frameID), []byte{})
+		})
+
+		if err != nil {
+			state.logger.Errorf("MarkFrameNew(%d): %s", frameID, err)
+		}
+
+		return
+	}
+
+	state.mutex.Lock()
+	state.newFrames[frameID] = true
+	state.mutex.Unlock()
+}
+
+func (state *State) GetNewFrames() map[int]bool {
+	if !state.persistent {
+		state.mutex.RLock()
+		defer state.mutex.RUnlock()
+		newFrames := make(map[int]bool, len(state.newFrames))
+
+		for frameID := range state.newFrames {
+			newFrames[frameID] = true
+		}
+
+		return newFrames
+	}
+
+	newFrames := make(map[int]bool)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(newFramePrefix); it.ValidForPrefix(newFramePrefix); it.Next() {
+			newFrames[decodeFrameIDKey(newFramePrefix, it.Item().Key())] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		state.logger.Errorf("GetNewFrames(): %s", err)
+	}
+
+	return newFrames
+}
+
+func (state *State) ClearNewFrames(frameIDs []int) {
+	if !state.persistent {
+		state.mutex.Lock()
+
+		for _, frameID := range frameIDs {
+			delete(state.newFrames, frameID)
+		}
+
+		state.mutex.Unlock()
+		return
+	}
+
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, frameID := range frameIDs {
+		if err := wb.Delete(encodeFrameIDKey(newFramePrefix, frameID)); err != nil {
+			state.logger.Errorf("ClearNewFrames(): %s", err)
+			return
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		state.logger.Errorf("ClearNewFrames(): %s", err)
+	}
+}
+
+func (state *State) GetLastRun() (*RunInfo, bool) {
+	if !state.persistent {
+		return nil, false
+	}
+
+	runInfo := new(RunInfo)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(lastRunKey)
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, runInfo)
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("GetLastRun(): %s", err)
+		}
+
+		return nil, false
+	}
+
+	return runInfo, true
+}
+
+func (state *State) SetLastRun(runInfo *RunInfo) {
+	if !state.persistent {
+		return
+	}
+
+	err := state.db.Update(func(txn *badger.Txn) error {
+		val, err := json.Marshal(runInfo)
+
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(lastRunKey, val)
+	})
+
+	if err != nil {
+		state.logger.Errorf("SetLastRun(): %s", err)
+	}
+}
+
+// Iterate over all stored comparison results
+
+func (state *State) ForEachComparison(visit func(frameID1, frameID2 int, info MatchScore)) {
+	if !state.persistent {
+		state.mutex.RLock()
+		defer state.mutex.RUnlock()
+
+		for key, info := range state.matchScores {
+			visit(key[0], key[1], info)
+		}
+
+		return
+	}
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(scorePrefix); it.ValidForPrefix(scorePrefix); it.Next() {
+			item := it.Item()
+			frameID1, frameID2 := decodeScoreKey(item.Key())
+
+			err := item.Value(func(val []byte) error {
+				visit(frameID1, frameID2, decodeScoreData(val))
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		state.logger.Errorf("ForEachComparison(): %s", err)
+	}
+}
+
+func (state *State) IsPersistent() bool {
+	return state.persistent
+}
+
+// Directory of persistent state (empty if the state isn't persistent)
+
+func (state *State) GetDataDirectory() string {
+	if !state.persistent {
+		return ""
+	}
+
+	return state.dataDirectory
+}
+
+var newFramePrefix = []byte("n:")
+var lastRunKey = []byte("l:run")
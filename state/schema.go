@@ -0,0 +1,240 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Version of the database layout. Stores created before versioning was introduced have no
+// version key and are treated as version 0. Whenever the layout changes incompatibly, the version
+// is bumped and a migration converting the previous layout is added to the list below, so
+// existing stores are upgraded when opened.
+
+const SchemaVersion = 2
+
+type migration struct {
+	version     int // schema version the migration produces
+	description string
+	migrate     func(state *State) error
+}
+
+var migrations = []migration{
+	{1, "repair malformed file records", repairFrameRecords},
+	{2, "key group decisions by all members", rekeyGroupDecisions},
+}
+
+// Check the schema version of an opened store and bring it up to date
+
+func (state *State) migrateSchema() error {
+	version, found, err := state.getSchemaVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		empty, err := state.isEmpty()
+
+		if err != nil {
+			return err
+		}
+
+		if empty {
+			return state.setSchemaVersion(SchemaVersion)
+		}
+	}
+
+	if version > SchemaVersion {
+		return fmt.Errorf("state in '%s' has schema version %d, but this version of vidsim only supports up to %d - please upgrade vidsim",
+			state.dataDirectory, version, SchemaVersion)
+	}
+
+	for _, step := range migrations {
+		if step.version <= version {
+			continue
+		}
+
+		state.logger.Infof("Migrating state to schema version %d: %s", step.version, step.description)
+
+		if err := step.migrate(state); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %w", step.version, err)
+		}
+
+		if err := state.setSchemaVersion(step.version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (state *State) getSchemaVersion() (int, bool, error) {
+	version := 0
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaVersionKey)
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			if len(val) != 8 {
+				return fmt.Errorf("malformed schema version (%d bytes)", len(val))
+			}
+
+			version = int(binary.BigEndian.Uint64(val))
+			return nil
+		})
+	})
+
+	if err == badger.ErrKeyNotFound {
+		return 0, false, nil
+	}
+
+	return version, err == nil, err
+}
+
+func (state *State) setSchemaVersion(version int) error {
+	return state.db.Update(func(txn *badger.Txn) error {
+		val := make([]byte, 8)
+		binary.BigEndian.PutUint64(val, uint64(version))
+		return txn.Set(schemaVersionKey, val)
+	})
+}
+
+func (state *State) isEmpty() (bool, error) {
+	empty := true
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+
+	return empty, err
+}
+
+// Version 1: file records must hold at least an 8-byte frame ID. Early versions of
+// setFileFrameIDPersistent() stored just the lowest byte of the frame ID (this has since been
+// fixed). Such a value is only unambiguous when no frame ID (of file or sample position records)
+// exceeds a byte; otherwise the record is deleted and the file is registered anew on the next run.
+
+func repairFrameRecords(state *State) error {
+	prefix := []byte(framePrefix)
+	malformed := make(map[string][]byte)
+	maxFrameID := 0
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			if len(val) < 8 {
+				malformed[string(item.KeyCopy(nil))] = val
+			} else {
+				maxFrameID = max(maxFrameID, decodeFrameValue(val))
+			}
+		}
+
+		for it.Seek(samplePrefix); it.ValidForPrefix(samplePrefix); it.Next() {
+			maxFrameID = max(maxFrameID, decodeFrameIDKey(samplePrefix, it.Item().Key()))
+		}
+
+		return nil
+	})
+
+	if err != nil || len(malformed) == 0 {
+		return err
+	}
+
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+	numRepaired := 0
+
+	for key, val := range malformed {
+		if len(val) == 1 && val[0] > 0 && maxFrameID <= 0xff {
+			err = wb.Set([]byte(key), encodeFrameValue(int(val[0])))
+			numRepaired++
+		} else {
+			err = wb.Delete([]byte(key))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = wb.Flush(); err != nil {
+		return err
+	}
+
+	state.logger.Infof("Repaired %d malformed file records, deleted %d", numRepaired, len(malformed)-numRepaired)
+	return nil
+}
+
+// Version 2: group decisions used to be keyed by the smallest frame ID of the group only, now
+// they are keyed by all members (see encodeDecisionKey())
+
+func rekeyGroupDecisions(state *State) error {
+	oldKeyLength := len(decisionPrefix) + 8
+
+	return state.db.Update(func(txn *badger.Txn) error {
+		decisions := make(map[string][]byte)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+
+		for it.Seek(decisionPrefix); it.ValidForPrefix(decisionPrefix); it.Next() {
+			item := it.Item()
+
+			if len(item.Key()) != oldKeyLength {
+				continue
+			}
+
+			val, err := item.ValueCopy(nil)
+
+			if err != nil {
+				it.Close()
+				return err
+			}
+
+			decisions[string(item.KeyCopy(nil))] = val
+		}
+
+		it.Close()
+
+		for key, val := range decisions {
+			if err := txn.Delete([]byte(key)); err != nil {
+				return err
+			}
+
+			var decision GroupDecision
+
+			if err := json.Unmarshal(val, &decision); err != nil || len(decision.Frames) < 2 {
+				continue // unusable, dropped
+			}
+
+			if err := txn.Set(encodeDecisionKey(decision.Frames), val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+var schemaVersionKey = []byte("v:schema")
@@ -0,0 +1,245 @@
+package state
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Persistent state kept in memory
+
+func newTestState(t *testing.T) *State {
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	state := MakeState()
+	state.logger = logger
+	state.db = db
+	state.persistent = true
+	state.dataDirectory = t.TempDir()
+	return state
+}
+
+func setRecords(t *testing.T, state *State, records map[string][]byte) {
+	t.Helper()
+
+	err := state.db.Update(func(txn *badger.Txn) error {
+		for key, val := range records {
+			if err := txn.Set([]byte(key), val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Frame IDs of all file records (stored path -> frame ID)
+
+func frameRecords(t *testing.T, state *State) map[string]int {
+	t.Helper()
+	records := make(map[string]int)
+	prefix := []byte(framePrefix)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			records[decodeFrameKey(it.Item().Key())] = decodeFrameValue(val[:8])
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestRepairFrameRecords(t *testing.T) {
+	stamp := make([]byte, 16)
+
+	tests := []struct {
+		name    string
+		records map[string][]byte // stored path -> file record
+		samples []int             // frame IDs with sample position records
+		want    map[string]int    // stored path -> frame ID after the repair
+	}{
+		{
+			name:    "no malformed records",
+			records: map[string][]byte{"a": encodeFrameValue(1), "b": append(encodeFrameValue(300), stamp...)},
+			want:    map[string]int{"a": 1, "b": 300},
+		},
+		{
+			name:    "one-byte records with frame IDs up to 255",
+			records: map[string][]byte{"a": {1}, "b": {255}, "c": encodeFrameValue(3)},
+			samples: []int{1, 255},
+			want:    map[string]int{"a": 1, "b": 255, "c": 3},
+		},
+		{
+			name:    "one-byte records with a file frame ID over 255",
+			records: map[string][]byte{"a": {1}, "b": encodeFrameValue(256)},
+			want:    map[string]int{"b": 256},
+		},
+		{
+			name:    "one-byte records with a sampled frame ID over 255",
+			records: map[string][]byte{"a": {1}},
+			samples: []int{1, 1000},
+			want:    map[string]int{},
+		},
+		{
+			name:    "zero frame ID",
+			records: map[string][]byte{"a": {0}, "b": encodeFrameValue(2)},
+			want:    map[string]int{"b": 2},
+		},
+		{
+			name:    "records of other lengths",
+			records: map[string][]byte{"a": {}, "b": {0, 1}, "c": encodeFrameValue(7)[:7]},
+			want:    map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTestState(t)
+			records := make(map[string][]byte)
+
+			for path, val := range tt.records {
+				records[string(encodeFrameKey(path))] = val
+			}
+
+			for _, frameID := range tt.samples {
+				records[string(encodeFrameIDKey(samplePrefix, frameID))] = []byte("[0.5]")
+			}
+
+			setRecords(t, state, records)
+
+			if err := repairFrameRecords(state); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := frameRecords(t, state); !mapsEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRekeyGroupDecisions(t *testing.T) {
+	decision := func(frames ...int) []byte {
+		val, _ := json.Marshal(&GroupDecision{Frames: frames, Confirmed: true, Keeper: frames[0]})
+		return val
+	}
+
+	tests := []struct {
+		name    string
+		records map[string][]byte // decision key -> value
+		want    [][]int           // members of the decisions after the migration
+	}{
+		{
+			name:    "old key of the smallest member",
+			records: map[string][]byte{string(encodeFrameIDKey(decisionPrefix, 3)): decision(3, 5, 8)},
+			want:    [][]int{{3, 5, 8}},
+		},
+		{
+			name: "old keys of overlapping groups",
+			records: map[string][]byte{
+				string(encodeFrameIDKey(decisionPrefix, 1)): decision(1, 2),
+				string(encodeFrameIDKey(decisionPrefix, 2)): decision(2, 3),
+			},
+			want: [][]int{{1, 2}, {2, 3}},
+		},
+		{
+			name: "unusable old decisions",
+			records: map[string][]byte{
+				string(encodeFrameIDKey(decisionPrefix, 1)): []byte("{"),
+				string(encodeFrameIDKey(decisionPrefix, 2)): decision(2),
+			},
+			want: nil,
+		},
+		{
+			name:    "new key",
+			records: map[string][]byte{string(encodeDecisionKey([]int{4, 6})): decision(4, 6)},
+			want:    [][]int{{4, 6}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTestState(t)
+			setRecords(t, state, tt.records)
+
+			if err := rekeyGroupDecisions(state); err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]int
+
+			err := state.db.View(func(txn *badger.Txn) error {
+				it := txn.NewIterator(badger.DefaultIteratorOptions)
+				defer it.Close()
+
+				for it.Seek(decisionPrefix); it.ValidForPrefix(decisionPrefix); it.Next() {
+					got = append(got, decodeDecisionKey(it.Item().Key()))
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]int]) {
+				t.Fatalf("got decisions of %v, want %v", got, tt.want)
+			}
+
+			for _, frames := range tt.want {
+				if found, ok := state.GetGroupDecision(frames); !ok || found.Keeper != frames[0] {
+					t.Errorf("decision of %v not found", frames)
+				}
+			}
+		})
+	}
+}
+
+func mapsEqual[K comparable, V comparable](m1, m2 map[K]V) bool {
+	if len(m1) != len(m2) {
+		return false
+	}
+
+	for key, val := range m1 {
+		if other, found := m2[key]; !found || other != val {
+			return false
+		}
+	}
+
+	return true
+}
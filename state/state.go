@@ -1,6 +1,7 @@
 package state
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -8,14 +9,36 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/sirupsen/logrus"
+	"github.com/vitali-fedulov/images4"
 )
 
-type matchScore struct {
-	Score         float32 // comparison score [0..1]
-	FalsePositive bool    // true for false positives
+type MatchScore struct {
+	Score         float32          // comparison score [0..1]
+	FalsePositive bool             // true for false positives
+	Distances     []SampleDistance // per-sample distances the score was derived from (if known)
+}
+
+// Distances between a pair of sampled frames, normalized by images4 default thresholds
+
+type SampleDistance struct {
+	Y    float32 // luma
+	Cb   float32 // chrominance b
+	Cr   float32 // chrominance r
+	Prop float32 // proportions
+}
+
+// Score with false positives represented as negative values
+
+func (info MatchScore) SignedScore() float32 {
+	if info.FalsePositive {
+		return -info.Score
+	}
+
+	return info.Score
 }
 
 type State struct {
@@ -24,8 +47,15 @@ type State struct {
 	image2frame   map[string]int // video filename -> frame ID
 	frame2image   map[int]string // frame ID -> video filename
 	nextframeID   int
+	roots         map[string]string // root name -> directory (see roots.go)
 
-	matchScores map[[2]int]matchScore // pair of frame IDs (ordered numerically) -> match score information
+	samplePositions map[int][]float64       // frame ID -> positions of the sampled frames
+	metadata        map[int]*VideoMetadata  // frame ID -> video metadata
+	icons           map[int][]images4.IconT // frame ID -> icons of the sampled frames
+	hashIndex       *hashIndex              // perceptual hash index of the sampled frames
+	newFrames       map[int]bool            // frames (re)generated since the last completed run
+
+	matchScores map[[2]int]MatchScore // pair of frame IDs (ordered numerically) -> match score information
 
 	mutex  *sync.RWMutex
 	db     *badger.DB
@@ -38,7 +68,13 @@ func MakeState() *State {
 	state.mutex = new(sync.RWMutex)
 	state.image2frame = make(map[string]int)
 	state.frame2image = make(map[int]string)
-	state.matchScores = make(map[[2]int]matchScore)
+	state.roots = make(map[string]string)
+	state.samplePositions = make(map[int][]float64)
+	state.metadata = make(map[int]*VideoMetadata)
+	state.icons = make(map[int][]images4.IconT)
+	state.hashIndex = newHashIndex()
+	state.newFrames = make(map[int]bool)
+	state.matchScores = make(map[[2]int]MatchScore)
 	state.nextframeID = 1
 
 	return state
@@ -51,7 +87,7 @@ func (state *State) Init(stateDirectory string, logger *logrus.Logger) error {
 		dirName, err := os.MkdirTemp("", "vidsim")
 
 		if err != nil {
-			state.logger.Fatalf("Failed to create a temporary directory: %s", err)
+			return fmt.Errorf("failed to create a temporary directory: %w", err)
 		}
 
 		state.dataDirectory = dirName
@@ -73,6 +109,16 @@ func (state *State) Init(stateDirectory string, logger *logrus.Logger) error {
 			return err
 		}
 
+		if err = state.migrateSchema(); err != nil {
+			state.db.Close()
+			return err
+		}
+
+		if err = state.resumeRenamePaths(); err != nil {
+			state.db.Close()
+			return fmt.Errorf("failed to complete an interrupted rename: %w", err)
+		}
+
 		maxID, err := state.getMaxFrameID()
 
 		if err != nil {
@@ -81,6 +127,12 @@ func (state *State) Init(stateDirectory string, logger *logrus.Logger) error {
 
 		state.nextframeID = maxID + 1
 		state.logger.Debugf("Next frame ID: %d", maxID)
+
+		if err = state.loadRoots(); err != nil {
+			return err
+		}
+
+		state.loadHashIndex()
 	}
 
 	return nil
@@ -113,11 +165,25 @@ func (state *State) RegisterFile(path string) (int, bool) {
 	return frameID, found
 }
 
+// Forget the frame ID of a file (e.g. after it's been deleted or moved). Other records of the
+// frame are removed by compaction.
+
 func (state *State) DeleteFile(path string) {
 	// state.mutex.Lock()
 	// defer state.mutex.Unlock()
 
-	state.logger.Fatal("DeleteFile called")
+	if state.persistent {
+		err := state.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(encodeFrameKey(state.storedPath(path)))
+		})
+
+		if err != nil {
+			state.logger.Errorf("DeleteFile('%s'): %s", path, err)
+		}
+
+		return
+	}
+
 	delete(state.image2frame, path)
 }
 
@@ -126,7 +192,7 @@ func (state *State) GetframeID(path string) (int, bool) {
 	// defer state.mutex.RUnlock()
 
 	if state.persistent {
-		frameID, found := state.getImageFramePersistent(path)
+		frameID, found := state.getImageFramePersistent(state.storedPath(path))
 
 		if found {
 			state.frame2image[frameID] = path
@@ -144,7 +210,7 @@ func (state *State) SetframeID(path string, frameID int) {
 	// defer state.mutex.Unlock()
 
 	if state.persistent {
-		state.setFileFrameIDPersistent(path, frameID)
+		state.setFileFrameIDPersistent(state.storedPath(path), frameID)
 		return
 	}
 
@@ -160,14 +226,85 @@ func (state *State) GetImageFile(frameID int) (string, bool) {
 	return path, found
 }
 
-func (state *State) GetFrameFileName(frameID int) string {
-	return filepath.Join(state.dataDirectory, fmt.Sprintf("frame%06d.jpg", frameID))
+// Iterate over all registered files
+
+func (state *State) ForEachFile(visit func(path string, frameID int)) {
+	if !state.persistent {
+		for path, frameID := range state.image2frame {
+			visit(path, frameID)
+		}
+
+		return
+	}
+
+	prefix := []byte(framePrefix)
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			path := state.realPath(decodeFrameKey(item.Key()))
+
+			err := item.Value(func(val []byte) error {
+				visit(path, decodeFrameValue(val))
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		state.logger.Errorf("ForEachFile(): %s", err)
+	}
 }
 
-func (state *State) GetComparisonScore(frameID1 int, frameID2 int) (float32, bool) {
+// Each video is represented by several frames sampled across its duration.
+// sample is the index of the frame in the list of sample positions.
+
+func (state *State) GetFrameFileName(frameID int, sample int) string {
+	return filepath.Join(state.dataDirectory, fmt.Sprintf("frame%06d_%02d.jpg", frameID, sample))
+}
+
+// Journals of file operations are kept alongside the state
+
+func (state *State) GetJournalFileName(name string) string {
+	return filepath.Join(state.dataDirectory, "journal", name)
+}
+
+// Get relative positions (0..1) at which the frames for a given frame ID were sampled
+
+func (state *State) GetSamplePositions(frameID int) ([]float64, bool) {
 	if state.persistent {
-		score, found := state.getComparisonScorePersistent(frameID1, frameID2)
-		return score, found
+		return state.getSamplePositionsPersistent(frameID)
+	}
+
+	state.mutex.RLock()
+	positions, found := state.samplePositions[frameID]
+	state.mutex.RUnlock()
+	return positions, found
+}
+
+func (state *State) SetSamplePositions(frameID int, positions []float64) {
+	if state.persistent {
+		state.setSamplePositionsPersistent(frameID, positions)
+		return
+	}
+
+	state.mutex.Lock()
+	state.samplePositions[frameID] = positions
+	state.mutex.Unlock()
+}
+
+func (state *State) GetComparison(frameID1 int, frameID2 int) (MatchScore, bool) {
+	if state.persistent {
+		return state.getComparisonPersistent(frameID1, frameID2)
 	}
 
 	// make sure frame IDs are ordered
@@ -181,21 +318,14 @@ func (state *State) GetComparisonScore(frameID1 int, frameID2 int) (float32, boo
 	state.mutex.RLock()
 	info, found := state.matchScores[key]
 	state.mutex.RUnlock()
-
-	if !found {
-		return 0, found
-	}
-
-	if info.FalsePositive {
-		return -info.Score, true
-	}
-
-	return info.Score, true
+	return info, found
 }
 
-func (state *State) SetComparisonScore(frameID1 int, frameID2 int, score float32) {
+// Save comparison results (the false positive flag of an existing record is preserved)
+
+func (state *State) SetComparison(frameID1 int, frameID2 int, score float32, distances []SampleDistance) {
 	if state.persistent {
-		state.setComparisonScorePersistent(frameID1, frameID2, score)
+		state.setComparisonPersistent(frameID1, frameID2, score, distances)
 		return
 	}
 
@@ -207,8 +337,42 @@ func (state *State) SetComparisonScore(frameID1 int, frameID2 int, score float32
 
 	key := [2]int{frameID1, frameID2}
 	state.mutex.Lock()
-	state.matchScores[key] = matchScore{Score: score, FalsePositive: false}
+	falsePositive := state.matchScores[key].FalsePositive
+	state.matchScores[key] = MatchScore{Score: score, FalsePositive: falsePositive, Distances: distances}
+	state.mutex.Unlock()
+}
+
+// Delete comparison scores involving any of the specified frames. False positive markers are
+// kept if requested since they reflect user's judgement rather than the frame contents.
+// Returns the number of deleted score records.
+
+func (state *State) DeleteComparisonScores(frameIDs map[int]bool, keepFalsePositives bool) int {
+	if len(frameIDs) == 0 {
+		return 0
+	}
+
+	if state.persistent {
+		return state.deleteComparisonScoresPersistent(frameIDs, keepFalsePositives)
+	}
+
+	numDeleted := 0
+	state.mutex.Lock()
+
+	for key, info := range state.matchScores {
+		if !frameIDs[key[0]] && !frameIDs[key[1]] {
+			continue
+		}
+
+		if keepFalsePositives && info.FalsePositive {
+			continue
+		}
+
+		delete(state.matchScores, key)
+		numDeleted++
+	}
+
 	state.mutex.Unlock()
+	return numDeleted
 }
 
 func (state *State) UnmatchFrames(frameID1, frameID2 int, falsePositive bool) {
@@ -275,9 +439,11 @@ func (state *State) AddFrameIDPersistent(path string) (int, bool) {
 
 	frameID := -1
 	found := false
+	movedFrom := ""
+	storedPath := state.storedPath(path)
 
 	err := state.db.Update(func(txn *badger.Txn) error {
-		key := encodeFrameKey(path)
+		key := encodeFrameKey(storedPath)
 		item, err := txn.Get(key)
 
 		if err == nil { // record with a given key found
@@ -291,9 +457,38 @@ func (state *State) AddFrameIDPersistent(path string) (int, bool) {
 			}
 
 			found = true
+
+			// files registered before fingerprints were introduced get one now
+
+			if !hasFingerprint(txn, frameID) {
+				if fingerprint, err := fileFingerprint(path); err == nil {
+					return state.setFingerprint(txn, frameID, storedPath, fingerprint)
+				}
+			}
+
 			return nil
 		}
 
+		// unknown path - check whether it's a known file that was moved
+
+		fingerprint, fingerprintErr := fileFingerprint(path)
+
+		if fingerprintErr == nil {
+			if movedID, oldPath, moved := state.findMovedFile(txn, storedPath, fingerprint); moved {
+				frameID, found, movedFrom = movedID, true, oldPath
+
+				if err = state.deleteFrameRecordOf(txn, oldPath, frameID); err != nil {
+					return err
+				}
+
+				if err = txn.Set(key, encodeFrameValue(frameID)); err != nil {
+					return err
+				}
+
+				return state.setFingerprint(txn, frameID, storedPath, fingerprint)
+			}
+		}
+
 		// record not found - create it
 
 		frameID = state.nextframeID
@@ -303,6 +498,10 @@ func (state *State) AddFrameIDPersistent(path string) (int, bool) {
 			return err
 		}
 
+		if fingerprintErr == nil {
+			return state.setFingerprint(txn, frameID, storedPath, fingerprint)
+		}
+
 		return nil
 	})
 
@@ -310,9 +509,118 @@ func (state *State) AddFrameIDPersistent(path string) (int, bool) {
 		state.logger.Errorf("AddFrameIDPersistent('%s'): %s", path, err)
 	}
 
+	// a moved file might not have been compared with the files around its new location
+
+	if movedFrom != "" {
+		state.logger.Infof("'%s' was moved from '%s'", path, state.realPath(movedFrom))
+		state.MarkFrameNew(frameID)
+	}
+
 	return frameID, found
 }
 
+// Delete the frame record of a path, provided it still refers to the given frame
+
+func (state *State) deleteFrameRecordOf(txn *badger.Txn, path string, frameID int) error {
+	key := encodeFrameKey(path)
+	item, err := txn.Get(key)
+
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	val, err := item.ValueCopy(nil)
+
+	if err != nil || decodeFrameValue(val) != frameID {
+		return err
+	}
+
+	return txn.Delete(key)
+}
+
+// Record the size and modification time of a registered file. Returns true if they differ from
+// the recorded ones, i.e. the file was modified or replaced since its frames were generated.
+// Files registered before the stamps were introduced just get one recorded.
+//
+// Frames, icons and metadata of a modified file are dropped right away: the new stamp is saved
+// before they are regenerated, so should that fail they must not be taken for the new contents.
+
+func (state *State) UpdateFileStamp(path string, size int64, modTime time.Time) bool {
+	if !state.persistent {
+		return false
+	}
+
+	modified := false
+	frameID := 0
+	storedPath := state.storedPath(path)
+
+	err := state.db.Update(func(txn *badger.Txn) error {
+		key := encodeFrameKey(storedPath)
+		item, err := txn.Get(key)
+
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		frameID = decodeFrameValue(val)
+		stamp := encodeFileStamp(size, modTime)
+
+		if len(val) > 8 {
+			if bytes.Equal(val[8:], stamp) {
+				return nil
+			}
+
+			modified = true
+		}
+
+		if err = txn.Set(key, append(encodeFrameValue(frameID), stamp...)); err != nil {
+			return err
+		}
+
+		if !modified {
+			return nil
+		}
+
+		for _, prefix := range [][]byte{samplePrefix, iconPrefix, metadataPrefix} {
+			if err = txn.Delete(encodeFrameIDKey(prefix, frameID)); err != nil {
+				return err
+			}
+		}
+
+		return state.refreshFingerprint(txn, frameID, storedPath)
+	})
+
+	if err != nil {
+		state.logger.Errorf("UpdateFileStamp('%s'): %s", path, err)
+	}
+
+	if modified {
+		state.deleteFrameFiles(frameID)
+	}
+
+	return modified
+}
+
+func (state *State) deleteFrameFiles(frameID int) {
+	frameFiles, _ := filepath.Glob(filepath.Join(state.dataDirectory, fmt.Sprintf("frame%06d_*.jpg", frameID)))
+
+	for _, frameFile := range frameFiles {
+		if err := os.Remove(frameFile); err != nil {
+			state.logger.Errorf("Failed to delete '%s': %s", frameFile, err)
+		}
+	}
+}
+
 func (state *State) GetFileFrameIDPersistent(path string) (int, bool) {
 	// state.mutex.Lock()
 	// defer state.mutex.Unlock()
@@ -363,16 +671,18 @@ func (state *State) getImageFramePersistent(path string) (int, bool) {
 	})
 
 	if err != nil {
-		state.logger.Errorf("getImageFramePersistent('%s'): %s", path, err)
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("getImageFramePersistent('%s'): %s", path, err)
+		}
+
 		return 0, false
 	}
 
 	return decodeFrameValue(valCopy), true
 }
 
-func (state *State) getComparisonScorePersistent(frameID1, frameID2 int) (float32, bool) {
-	var score float32
-	var falsePositive bool
+func (state *State) getComparisonPersistent(frameID1, frameID2 int) (MatchScore, bool) {
+	var info MatchScore
 
 	err := state.db.View(func(txn *badger.Txn) error {
 		key := encodeScoreKey(frameID1, frameID2)
@@ -383,7 +693,7 @@ func (state *State) getComparisonScorePersistent(frameID1, frameID2 int) (float3
 		}
 
 		err = item.Value(func(val []byte) error {
-			score, falsePositive = decodeScoreData(val)
+			info = decodeScoreData(val)
 			return nil
 		})
 
@@ -392,23 +702,19 @@ func (state *State) getComparisonScorePersistent(frameID1, frameID2 int) (float3
 
 	if err != nil {
 		if err != badger.ErrKeyNotFound {
-			state.logger.Errorf("GetComparisonScorePersistent(%d, %d): %s",
+			state.logger.Errorf("getComparisonPersistent(%d, %d): %s",
 				frameID1, frameID2, err)
 		}
 
-		return 0, false
-	}
-
-	if falsePositive {
-		score = -score
+		return MatchScore{}, false
 	}
 
-	return score, true
+	return info, true
 }
 
 func (state *State) setFileFrameIDPersistent(path string, frameID int) {
 	err := state.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(encodeFrameKey(path), []byte{byte(frameID)})
+		return txn.Set(encodeFrameKey(path), encodeFrameValue(frameID))
 	})
 
 	if err != nil {
@@ -416,19 +722,35 @@ func (state *State) setFileFrameIDPersistent(path string, frameID int) {
 	}
 }
 
-func (state *State) setComparisonScorePersistent(frameID1, frameID2 int, score float32) {
+func (state *State) setComparisonPersistent(frameID1, frameID2 int, score float32, distances []SampleDistance) {
 	err := state.db.Update(func(txn *badger.Txn) error {
-		val, err := encodeScoreData(score, false)
+		key := encodeScoreKey(frameID1, frameID2)
+		falsePositive := false
+
+		if item, err := txn.Get(key); err == nil {
+			err = item.Value(func(val []byte) error {
+				falsePositive = decodeScoreData(val).FalsePositive
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		val, err := encodeScoreData(score, falsePositive, distances)
 
 		if err != nil {
 			return err
 		}
 
-		return txn.Set(encodeScoreKey(frameID1, frameID2), val)
+		return txn.Set(key, val)
 	})
 
 	if err != nil {
-		state.logger.Errorf("setComparisonScorePersistent(%d, %d): %s",
+		state.logger.Errorf("setComparisonPersistent(%d, %d): %s",
 			frameID1, frameID2, err)
 	}
 }
@@ -438,15 +760,30 @@ func (state *State) unmatchFramesPersistent(frameID1, frameID2 int, falsePositiv
 		key := encodeScoreKey(frameID1, frameID2)
 		item, err := txn.Get(key)
 
+		// pairs that were never compared (e.g. grouped transitively) get a record of their own
+
+		if err == badger.ErrKeyNotFound && falsePositive {
+			val, err := encodeScoreData(1, true, nil)
+
+			if err != nil {
+				return err
+			}
+
+			return txn.Set(key, val)
+		}
+
 		if err != nil {
 			return err
 		}
 
-		err = item.Value(func(val []byte) error {
-			val[4] = boolToByte(falsePositive) // Update only the IsValid byte
-			return txn.Set(key, val)
-		})
-		return err
+		val, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		val[4] = boolToByte(falsePositive) // Update only the IsValid byte
+		return txn.Set(key, val)
 	})
 
 	if err != nil {
@@ -455,6 +792,141 @@ func (state *State) unmatchFramesPersistent(frameID1, frameID2 int, falsePositiv
 	}
 }
 
+func (state *State) getSamplePositionsPersistent(frameID int) ([]float64, bool) {
+	var positions []float64
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeFrameIDKey(samplePrefix, frameID))
+
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			positions = decodeSamplePositions(val)
+			return nil
+		})
+	})
+
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			state.logger.Errorf("getSamplePositionsPersistent(%d): %s", frameID, err)
+		}
+
+		return nil, false
+	}
+
+	return positions, true
+}
+
+func (state *State) setSamplePositionsPersistent(frameID int, positions []float64) {
+	err := state.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(encodeFrameIDKey(samplePrefix, frameID), encodeSamplePositions(positions))
+	})
+
+	if err != nil {
+		state.logger.Errorf("setSamplePositionsPersistent(%d): %s", frameID, err)
+	}
+}
+
+func (state *State) deleteComparisonScoresPersistent(frameIDs map[int]bool, keepFalsePositives bool) int {
+	numDeleted := 0
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(scorePrefix); it.ValidForPrefix(scorePrefix); it.Next() {
+			item := it.Item()
+			frameID1, frameID2 := decodeScoreKey(item.Key())
+
+			if !frameIDs[frameID1] && !frameIDs[frameID2] {
+				continue
+			}
+
+			if keepFalsePositives {
+				var falsePositive bool
+				err := item.Value(func(val []byte) error {
+					falsePositive = decodeScoreData(val).FalsePositive
+					return nil
+				})
+
+				if err != nil {
+					return err
+				}
+
+				if falsePositive {
+					continue
+				}
+			}
+
+			if err := wb.Delete(item.KeyCopy(nil)); err != nil {
+				return err
+			}
+
+			numDeleted++
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+
+	if err != nil {
+		state.logger.Errorf("deleteComparisonScoresPersistent(): %s", err)
+	}
+
+	return numDeleted
+}
+
+// Delete per-frame records (keyed by a prefix followed by the frame ID) for frames that are not
+// in the valid set. Returns the number of records examined and deleted.
+
+func (state *State) deleteStaleFrameRecords(prefix []byte, validFrames map[int]bool) (int, int) {
+	numRecords := 0
+	numDeleted := 0
+	wb := state.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := state.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			numRecords++
+			key := it.Item().KeyCopy(nil)
+
+			if validFrames[decodeFrameIDKey(prefix, key)] {
+				continue
+			}
+
+			if err := wb.Delete(key); err != nil {
+				return err
+			}
+
+			numDeleted++
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+
+	if err != nil {
+		state.logger.Errorf("Failed to delete stale '%s' records: %v", prefix, err)
+	}
+
+	return numRecords, numDeleted
+}
+
 func (state *State) CompactDataStore() error {
 	if !state.persistent {
 		return errors.New("only supported with persistence")
@@ -462,8 +934,9 @@ func (state *State) CompactDataStore() error {
 
 	prefix := []byte(framePrefix)
 
-	// Step 1 - make sure we are in the right directory. Filenames are stored as relative paths so running
-	// from a wrong place might result in "not files exist anymore" situation, effectively wiping out the state.
+	// Step 1 - make sure we are in the right directory. Filenames outside of named roots are stored as relative
+	// paths so running from a wrong place might result in "not files exist anymore" situation, effectively wiping
+	// out the state.
 
 	const minViableFraction = 0.4 // at least 40% of files should exist in order to start deleting the entries
 	numFrameEntries := 0
@@ -480,7 +953,7 @@ func (state *State) CompactDataStore() error {
 			filename := decodeFrameKey(item.Key())
 			numFrameEntries++
 
-			if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			if _, err := os.Stat(state.realPath(filename)); !os.IsNotExist(err) {
 				numExistingFiles++
 			}
 		}
@@ -522,7 +995,7 @@ func (state *State) CompactDataStore() error {
 
 			// delete frame entries for non-existent files
 
-			if _, err := os.Stat(filename); os.IsNotExist(err) {
+			if _, err := os.Stat(state.realPath(filename)); os.IsNotExist(err) {
 				state.logger.Debugf("Deleting frame record for '%s'", filename)
 				err := txn.Delete(key)
 
@@ -542,7 +1015,6 @@ func (state *State) CompactDataStore() error {
 
 				frameID := decodeFrameValue(value)
 				validFrames[frameID] = true
-				validImages[state.GetFrameFileName(frameID)] = true
 			}
 		}
 		return nil
@@ -597,6 +1069,26 @@ func (state *State) CompactDataStore() error {
 		state.logger.Errorf("Error during scores compaction: %v", err)
 	}
 
+	for frameID := range validFrames {
+		positions, _ := state.getSamplePositionsPersistent(frameID)
+
+		for ii := range positions {
+			validImages[state.GetFrameFileName(frameID, ii)] = true
+		}
+	}
+
+	state.deleteStaleFrameRecords(samplePrefix, validFrames)
+	state.deleteStaleFrameRecords(metadataPrefix, validFrames)
+	state.deleteStaleFrameRecords(iconPrefix, validFrames)
+	state.deleteStaleFrameRecords(newFramePrefix, validFrames)
+	state.deleteStaleDecisions(validFrames)
+	state.deleteStaleFingerprints(validFrames)
+	state.PruneHashIndex(validFrames)
+
+	if err = state.SaveHashIndex(); err != nil {
+		state.logger.Errorf("Failed to save hash index: %v", err)
+	}
+
 	err = state.db.RunValueLogGC(0.5) // GC the log
 
 	if err != nil && err != badger.ErrNoRewrite {
@@ -654,6 +1146,7 @@ Summary:
 
 var framePrefix = "f:"
 var scorePrefix = []byte("s:")
+var samplePrefix = []byte("p:")
 var prefixKeyLength = -1
 
 func encodeFrameKey(path string) []byte {
@@ -680,6 +1173,49 @@ func decodeFrameValue(encoded []byte) int {
 	return int(binary.BigEndian.Uint64(encoded))
 }
 
+// Frame records may be followed by a stamp of the file (size and modification time), which
+// decodeFrameValue() ignores
+
+func encodeFileStamp(size int64, modTime time.Time) []byte {
+	stamp := make([]byte, 16)
+	binary.BigEndian.PutUint64(stamp, uint64(size))
+	binary.BigEndian.PutUint64(stamp[8:], uint64(modTime.UnixNano()))
+	return stamp
+}
+
+// Key for per-frame records: prefix followed by the frame ID
+
+func encodeFrameIDKey(prefix []byte, frameID int) []byte {
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(frameID))
+	return key
+}
+
+func decodeFrameIDKey(prefix []byte, encoded []byte) int {
+	return int(binary.BigEndian.Uint64(encoded[len(prefix):]))
+}
+
+func encodeSamplePositions(positions []float64) []byte {
+	b := make([]byte, 4*len(positions))
+
+	for ii, pos := range positions {
+		binary.BigEndian.PutUint32(b[4*ii:], math.Float32bits(float32(pos)))
+	}
+
+	return b
+}
+
+func decodeSamplePositions(encoded []byte) []float64 {
+	positions := make([]float64, len(encoded)/4)
+
+	for ii := range positions {
+		positions[ii] = float64(math.Float32frombits(binary.BigEndian.Uint32(encoded[4*ii:])))
+	}
+
+	return positions
+}
+
 func encodeScoreKey(frameID1, frameID2 int) []byte {
 	keyLen := len(scorePrefix) + 2*8 // prefix + 2 * uint64
 
@@ -703,17 +1239,39 @@ func decodeScoreKey(encoded []byte) (int, int) {
 	return frameID1, frameID2
 }
 
-func encodeScoreData(score float32, falsePositive bool) ([]byte, error) {
-	b := make([]byte, 5) // 4 bytes (float32) + 1 byte (bool)
+// Score data: score (float32), false positive flag (1 byte), followed by optional
+// per-sample distances (4 float32 values each)
+
+func encodeScoreData(score float32, falsePositive bool, distances []SampleDistance) ([]byte, error) {
+	b := make([]byte, 5+16*len(distances))
 	binary.BigEndian.PutUint32(b, math.Float32bits(score))
 	b[4] = boolToByte(falsePositive)
+	offset := 5
+
+	for _, distance := range distances {
+		for _, value := range []float32{distance.Y, distance.Cb, distance.Cr, distance.Prop} {
+			binary.BigEndian.PutUint32(b[offset:], math.Float32bits(value))
+			offset += 4
+		}
+	}
+
 	return b, nil
 }
 
-func decodeScoreData(encoded []byte) (float32, bool) {
-	score := math.Float32frombits(binary.BigEndian.Uint32(encoded[:4]))
-	falsePositive := encoded[4] != 0
-	return score, falsePositive
+func decodeScoreData(encoded []byte) MatchScore {
+	var info MatchScore
+	info.Score = math.Float32frombits(binary.BigEndian.Uint32(encoded[:4]))
+	info.FalsePositive = encoded[4] != 0
+
+	for offset := 5; offset+16 <= len(encoded); offset += 16 {
+		value := func(idx int) float32 {
+			return math.Float32frombits(binary.BigEndian.Uint32(encoded[offset+4*idx:]))
+		}
+
+		info.Distances = append(info.Distances, SampleDistance{Y: value(0), Cb: value(1), Cr: value(2), Prop: value(3)})
+	}
+
+	return info
 }
 
 func boolToByte(b bool) byte {